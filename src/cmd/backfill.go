@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Backfill answers the question in the thread at threadTs, regardless of
+// the history window, replies or whether it was answered before. The
+// question is the thread's first human message that looks like one, or
+// the thread's parent when none does. It is meant for re-answering a
+// thread by hand, e.g. after the prompt was fixed.
+func Backfill(ctx context.Context, cfg Config, channelId, threadTs string) error {
+	r, err := newRunner(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer cfg.Notifier.Wait()
+
+	replies, err := cfg.Slack.FetchThreadReplies(ctx, channelId, threadTs)
+	if err != nil {
+		return fmt.Errorf("fetching thread %s in %s: %w", threadTs, channelId, err)
+	}
+
+	question, ok := r.findQuestion(channelId, threadTs, replies)
+	if !ok {
+		return fmt.Errorf("no question from a person in thread %s in %s", threadTs, channelId)
+	}
+
+	slog.Info("Backfilling answer", "channel", channelId, "thread_ts", threadTs, "ts", question.Ts, "user", question.User)
+	return r.answer(ctx, channelId, question)
+}
+
+// findQuestion picks the message of a thread to answer: the first human
+// message that is a question, or else the human parent at threadTs.
+func (r *runner) findQuestion(channelId, threadTs string, replies []SlackMessage) (SlackMessage, bool) {
+	cfg := r.cfg.forChannel(channelId)
+	for _, reply := range replies {
+		if r.isHumanMessage(reply) && cfg.isQuestion(reply.Text) {
+			return reply, true
+		}
+	}
+	for _, reply := range replies {
+		if reply.Ts == threadTs && r.isHumanMessage(reply) {
+			return reply, true
+		}
+	}
+	return SlackMessage{}, false
+}
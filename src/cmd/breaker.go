@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreakerThreshold is how many ChatGPT outages in a row open
+	// the breaker when CIRCUIT_BREAKER_THRESHOLD is not set.
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldownSeconds is how long an open breaker rejects
+	// calls when CIRCUIT_BREAKER_COOLDOWN_SECONDS is not set.
+	DefaultBreakerCooldownSeconds = 60
+)
+
+// ErrCircuitOpen is returned instead of calling ChatGPT while the breaker
+// is open. It is transient: the call may succeed after the cooldown.
+var ErrCircuitOpen = fmt.Errorf("%w: chatgpt circuit breaker is open", ErrTransient)
+
+// CircuitBreaker stops calls to a failing backend. After Threshold
+// consecutive failures it opens and rejects calls for Cooldown; then it
+// lets a single probe through, closing again if the probe succeeds and
+// reopening if it fails. A nil breaker, or a zero Threshold, never opens.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+	now      func() time.Time
+}
+
+// Allow returns ErrCircuitOpen when a call should not be made.
+func (b *CircuitBreaker) Allow() error {
+	if b == nil || b.Threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.Threshold {
+		return nil
+	}
+	if b.probing || b.clock().Sub(b.openedAt) < b.Cooldown {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	slog.Info("ChatGPT circuit breaker half-open, probing")
+	return nil
+}
+
+// Record updates the breaker with the outcome of an allowed call. Only
+// outages count as failures; errors a retry could not fix, such as a bad
+// request, say nothing about whether the backend is up.
+func (b *CircuitBreaker) Record(err error) {
+	if b == nil || b.Threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasProbing := b.probing
+	b.probing = false
+	if err == nil {
+		if b.failures >= b.Threshold {
+			slog.Info("ChatGPT circuit breaker closed")
+		}
+		b.failures = 0
+		return
+	}
+	if !isOutage(err) {
+		return
+	}
+
+	b.failures++
+	if b.failures == b.Threshold || wasProbing {
+		b.openedAt = b.clock()
+		slog.Warn("ChatGPT circuit breaker opened", "failures", b.failures, "cooldown", b.Cooldown)
+	}
+}
+
+func (b *CircuitBreaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+// isOutage reports whether err means the backend is unavailable rather
+// than that the request itself was wrong.
+func isOutage(err error) bool {
+	return errors.Is(err, ErrTransient) || errors.Is(err, ErrRateLimit) || errors.Is(err, ErrChatGptRetriesExhausted)
+}
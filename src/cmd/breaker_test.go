@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(1704150000, 0)
+	b := &CircuitBreaker{Threshold: 2, Cooldown: time.Minute, now: func() time.Time { return now }}
+	outage := &ChatGptApiError{StatusCode: 503}
+
+	b.Record(errors.New("bad request"))
+	b.Record(outage)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after one outage = %v, want nil", err)
+	}
+
+	b.Record(outage)
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow() after threshold = %v, want ErrCircuitOpen", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after cooldown = %v, want nil probe", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow() during probe = %v, want ErrCircuitOpen", err)
+	}
+
+	b.Record(outage)
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow() after failed probe = %v, want ErrCircuitOpen", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after second cooldown = %v, want nil probe", err)
+	}
+	b.Record(nil)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after successful probe = %v, want nil", err)
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() once closed = %v, want nil", err)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	var nilBreaker *CircuitBreaker
+	nilBreaker.Record(&ChatGptApiError{StatusCode: 503})
+	if err := nilBreaker.Allow(); err != nil {
+		t.Errorf("nil Allow() = %v, want nil", err)
+	}
+
+	b := &CircuitBreaker{}
+	for i := 0; i < 10; i++ {
+		b.Record(&ChatGptApiError{StatusCode: 503})
+	}
+	if err := b.Allow(); err != nil {
+		t.Errorf("zero-threshold Allow() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ChannelConfig overrides global settings for one channel. Empty fields
+// keep the global value.
+type ChannelConfig struct {
+	Model            string   `json:"model"`
+	SystemPrompt     string   `json:"system_prompt"`
+	QuestionKeywords []string `json:"question_keywords"`
+}
+
+// loadChannelConfig reads the CHANNEL_CONFIG file at path: a JSON object
+// mapping channel IDs to ChannelConfig. An empty path yields no overrides.
+// Unknown fields are rejected so a typo does not silently fall back to
+// the global settings.
+func loadChannelConfig(path string) (map[string]ChannelConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var channels map[string]ChannelConfig
+	err = decoder.Decode(&channels)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	for id, channel := range channels {
+		if strings.TrimSpace(id) == "" {
+			return nil, fmt.Errorf("parsing %s: empty channel ID", path)
+		}
+		for _, keyword := range channel.QuestionKeywords {
+			if strings.TrimSpace(keyword) == "" {
+				return nil, fmt.Errorf("parsing %s: empty question keyword for channel %s", path, id)
+			}
+		}
+	}
+	return channels, nil
+}
+
+// chatOptions returns the ChatGPT overrides configured for channelId.
+func (cfg Config) chatOptions(channelId string) ChatOptions {
+	channel := cfg.Channels[channelId]
+	return ChatOptions{Model: channel.Model, SystemPrompt: channel.SystemPrompt}
+}
+
+// forChannel returns cfg with channelId's question keywords applied. A
+// channel's own keywords replace QUESTION_REGEX too, since isQuestion
+// would otherwise never look at them.
+func (cfg Config) forChannel(channelId string) Config {
+	if keywords := cfg.Channels[channelId].QuestionKeywords; len(keywords) > 0 {
+		cfg.QuestionKeywords = keywords
+		cfg.QuestionRegex = nil
+	}
+	return cfg
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultOpenAIBaseUrl is used when OPENAI_BASE_URL is not set.
+	DefaultOpenAIBaseUrl = "https://api.openai.com/v1"
+	// DefaultChatGptMaxAttempts is how many times a rate-limited or
+	// failing ChatGPT request is tried before giving up when
+	// OPENAI_MAX_ATTEMPTS is not set.
+	DefaultChatGptMaxAttempts = 3
+	// DefaultChatGptModel is used when OPENAI_MODEL is not set.
+	DefaultChatGptModel = "gpt-3.5-turbo"
+
+	ApiTypeOpenAI = "openai"
+	ApiTypeAzure  = "azure"
+	// DefaultAzureApiVersion is used when AZURE_OPENAI_API_VERSION is not
+	// set.
+	DefaultAzureApiVersion = "2024-02-01"
+	// MaxStreamLineBytes bounds a single server-sent event line.
+	MaxStreamLineBytes = 1 << 20
+
+	// JsonModeInstruction is sent with JSON_MODE, whose response_format
+	// is rejected unless the messages mention JSON.
+	JsonModeInstruction = "Reply with a single valid JSON object."
+
+	// MaxStopSequences is the most stop sequences the API accepts.
+	MaxStopSequences = 4
+
+	// DefaultFallbackMessage is answered when ChatGPT returns no choices
+	// and FALLBACK_MESSAGE is not set.
+	DefaultFallbackMessage = "Sorry, I couldn't come up with an answer this time. Please try asking again later."
+)
+
+// ErrChatGptRetriesExhausted is returned when ChatGPT kept answering with
+// 429 or 5xx until MaxAttempts was reached.
+var ErrChatGptRetriesExhausted = errors.New("chatgpt API retries exhausted")
+
+// ChatClient answers a prompt, given the conversation that preceded it.
+type ChatClient interface {
+	Send(ctx context.Context, history []ChatMessage, prompt string, opts ChatOptions) (string, error)
+}
+
+// ChatOptions overrides the client's defaults for a single Send. Empty
+// fields keep the client's value.
+type ChatOptions struct {
+	Model        string
+	SystemPrompt string
+	// Images are data URLs attached to the prompt.
+	Images []string
+}
+
+// HttpChatClient implements ChatClient against the OpenAI chat
+// completions API.
+type HttpChatClient struct {
+	HttpClient *http.Client
+	// Timeout cancels each request, including reading a streamed answer,
+	// that takes longer. Zero means no timeout.
+	Timeout time.Duration
+	ApiKey  string
+	Model   string
+	// MaxTokens is sent as max_tokens only when positive, so leaving it
+	// zero keeps OpenAI's own default.
+	MaxTokens int
+	// Temperature is nil unless configured, so an explicit 0 is
+	// distinguishable from "use OpenAI's default".
+	Temperature *float64
+	// TopP, PresencePenalty and FrequencyPenalty, like Temperature, are
+	// sent only when configured.
+	TopP             *float64
+	PresencePenalty  *float64
+	FrequencyPenalty *float64
+	// JsonMode requests a JSON object answer. The API requires the
+	// messages to ask for JSON, so JsonModeInstruction is added to them.
+	JsonMode bool
+	// Stop ends the answer at the first of these sequences, up to
+	// MaxStopSequences of them.
+	Stop         []string
+	SystemPrompt string
+	// FewShot are example messages sent after the system prompt and
+	// before the thread history, to set the tone of answers.
+	FewShot []ChatMessage
+	// RedactPII masks emails and secrets in history and prompt before
+	// they leave for OpenAI. The system prompt is operator-controlled and
+	// sent as is.
+	RedactPII bool
+	// FallbackModels are tried in order when Model is overloaded, rate
+	// limited or missing. They are ignored for Azure, where the
+	// deployment rather than the model name picks the model.
+	FallbackModels []string
+	// Stream requests a server-sent event stream and assembles the answer
+	// from its deltas, so a long answer keeps the connection active.
+	Stream bool
+
+	// ApiType selects the backend: ApiTypeOpenAI (the default) or
+	// ApiTypeAzure. Azure uses the Azure* fields to build the URL and sends
+	// ApiKey in an api-key header instead of a bearer token.
+	ApiType string
+	// BaseUrl is the OpenAI API root, e.g. a gateway or an
+	// OpenAI-compatible server. It is ignored for Azure.
+	BaseUrl         string
+	AzureEndpoint   string
+	AzureDeployment string
+	AzureApiVersion string
+	// FallbackMessage is answered when ChatGPT returns no choices; API
+	// errors are returned as errors instead. Empty means
+	// DefaultFallbackMessage.
+	FallbackMessage string
+	// Breaker, when set, stops calling OpenAI during an outage.
+	Breaker *CircuitBreaker
+	// MaxAttempts bounds how often a rate-limited or failing request is
+	// tried. Zero means DefaultChatGptMaxAttempts.
+	MaxAttempts int
+	// OrgId and ProjectId scope billing and rate limits on multi-org
+	// accounts. Their headers are sent only when set.
+	OrgId     string
+	ProjectId string
+
+	mu sync.Mutex
+	// usage is keyed by model, as fallback and per-channel models are
+	// priced differently from Model.
+	usage map[string]ChatGptUsage
+}
+
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Images are data URLs sent alongside Content to vision models.
+	Images []string `json:"-"`
+}
+
+// MarshalJSON sends a message with Images as a multimodal content array
+// of one text part followed by image_url parts, and any other message
+// with plain string content.
+func (m ChatMessage) MarshalJSON() ([]byte, error) {
+	type plain ChatMessage
+	if len(m.Images) == 0 {
+		return json.Marshal(plain(m))
+	}
+
+	parts := []map[string]interface{}{{"type": "text", "text": m.Content}}
+	for _, url := range m.Images {
+		parts = append(parts, map[string]interface{}{
+			"type":      "image_url",
+			"image_url": map[string]string{"url": url},
+		})
+	}
+	return json.Marshal(map[string]interface{}{"role": m.Role, "content": parts})
+}
+
+type ChatGPTPayLoad struct {
+	Model       string        `json:"model"`
+	Messages    []ChatMessage `json:"messages"`
+	MaxTokens   int           `json:"max_tokens,omitempty"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	// TopP samples from the smallest set of tokens whose probability
+	// adds up to it, as an alternative to Temperature.
+	TopP *float64 `json:"top_p,omitempty"`
+	// PresencePenalty and FrequencyPenalty discourage repetition; both
+	// range from -2 to 2.
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	// ResponseFormat constrains the answer's shape, e.g. to a JSON object.
+	ResponseFormat *ChatGptResponseFormat `json:"response_format,omitempty"`
+	Stream         bool                   `json:"stream,omitempty"`
+	// StreamOptions asks for a final chunk carrying usage, which streamed
+	// responses otherwise leave out.
+	StreamOptions *ChatGptStreamOptions `json:"stream_options,omitempty"`
+}
+
+type ChatGptResponseFormat struct {
+	Type string `json:"type"`
+}
+
+type ChatGptStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// ChatGptStreamChunk is one server-sent event of a streamed response.
+type ChatGptStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *ChatGptUsage    `json:"usage"`
+	Error *ChatGptApiError `json:"error"`
+}
+
+type ChatGptResponse struct {
+	Choices []struct {
+		Message struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage ChatGptUsage     `json:"usage"`
+	Error *ChatGptApiError `json:"error"`
+}
+
+// ChatGptUsage is the token count OpenAI bills a request by.
+type ChatGptUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// ChatGptApiError mirrors the error object OpenAI returns in place of
+// choices, e.g. for an invalid API key or unknown model.
+type ChatGptApiError struct {
+	Message    string `json:"message"`
+	Type       string `json:"type"`
+	Code       string `json:"code"`
+	StatusCode int    `json:"-"`
+}
+
+func (e *ChatGptApiError) Error() string {
+	return fmt.Sprintf("chatgpt API error (status %d, type %s, code %s): %s", e.StatusCode, e.Type, e.Code, e.Message)
+}
+
+func (e *ChatGptApiError) Is(target error) bool {
+	return target != nil && classifyStatus(e.StatusCode) == target
+}
+
+// Send asks ChatGPT to answer prompt, with history sent as the preceding
+// conversation.
+func (c *HttpChatClient) Send(ctx context.Context, history []ChatMessage, prompt string, opts ChatOptions) (string, error) {
+	primary := c.Model
+	if opts.Model != "" {
+		primary = opts.Model
+	}
+	if primary == "" {
+		return "", errors.New("chatgpt model is not configured")
+	}
+	systemPrompt := c.SystemPrompt
+	if opts.SystemPrompt != "" {
+		systemPrompt = opts.SystemPrompt
+	}
+
+	var message []ChatMessage
+	// The system message must come first for the API to apply it.
+	if systemPrompt != "" {
+		message = append(message, ChatMessage{
+			Role:    "system",
+			Content: systemPrompt,
+		})
+	}
+	if c.JsonMode {
+		message = append(message, ChatMessage{Role: "system", Content: JsonModeInstruction})
+	}
+	message = append(message, c.FewShot...)
+	// Everything up to here is kept when the prompt must be trimmed.
+	keep := len(message)
+	if c.RedactPII {
+		prompt = redact(prompt)
+	}
+	for _, m := range history {
+		if c.RedactPII {
+			m.Content = redact(m.Content)
+		}
+		message = append(message, m)
+	}
+	message = append(message, ChatMessage{
+		Role:    "user",
+		Content: prompt,
+		Images:  opts.Images,
+	})
+
+	err := c.Breaker.Allow()
+	if err != nil {
+		return "", err
+	}
+
+	models := []string{primary}
+	if c.ApiType != ApiTypeAzure {
+		models = append(models, c.FallbackModels...)
+	}
+
+	for i, model := range models {
+		var answer string
+		// fitContext trims in place, and each model has its own budget.
+		answer, err = c.complete(ctx, model, append([]ChatMessage(nil), message...), keep)
+		if err == nil {
+			if i > 0 {
+				slog.Info("Answered with fallback model", "model", model, "primary", primary)
+			}
+			c.Breaker.Record(nil)
+			return answer, nil
+		}
+		if !shouldFallback(err) || i == len(models)-1 {
+			break
+		}
+		slog.Warn("ChatGPT model failed, trying fallback", "model", model, "fallback", models[i+1], "class", errorClass(err), "error", err)
+	}
+	c.Breaker.Record(err)
+	return "", err
+}
+
+// complete sends messages to model and returns the answer, retrying
+// rate-limited and failing requests up to MaxAttempts times. The
+// first keep messages are never trimmed to fit the context.
+func (c *HttpChatClient) complete(ctx context.Context, model string, message []ChatMessage, keep int) (string, error) {
+	message, err := fitContext(message, keep, model, c.MaxTokens)
+	if err != nil {
+		return "", err
+	}
+
+	requestData := ChatGPTPayLoad{
+		Model:    model,
+		Messages: message,
+	}
+	if c.MaxTokens > 0 {
+		requestData.MaxTokens = c.MaxTokens
+	}
+	requestData.Temperature = c.Temperature
+	requestData.TopP = c.TopP
+	requestData.PresencePenalty = c.PresencePenalty
+	requestData.FrequencyPenalty = c.FrequencyPenalty
+	requestData.Stop = c.Stop
+	if c.JsonMode {
+		requestData.ResponseFormat = &ChatGptResponseFormat{Type: "json_object"}
+	}
+	if c.Stream {
+		requestData.Stream = true
+		// Azure rejects stream_options on older API versions.
+		if c.ApiType != ApiTypeAzure {
+			requestData.StreamOptions = &ChatGptStreamOptions{IncludeUsage: true}
+		}
+	}
+
+	jsonData, err := json.Marshal(requestData)
+	if err != nil {
+		return "", err
+	}
+
+	var resp *http.Response
+	for attempt := 1; ; attempt++ {
+		reqCtx, cancel := requestContext(ctx, c.Timeout)
+		req, err := http.NewRequestWithContext(reqCtx, "POST", c.url(), bytes.NewBuffer(jsonData))
+		if err != nil {
+			cancel()
+			return "", err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		c.setAuthHeaders(req)
+
+		resp, err = c.HttpClient.Do(req)
+		if err != nil {
+			cancel()
+			return "", fmt.Errorf("%w: %w", ErrTransient, err)
+		}
+
+		if !isRetryableStatus(resp.StatusCode) {
+			defer cancel()
+			break
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		cancel()
+
+		if attempt >= c.maxAttempts() {
+			return "", fmt.Errorf("%w (%w): status %d after %d attempts", ErrChatGptRetriesExhausted, classifyStatus(resp.StatusCode), resp.StatusCode, attempt)
+		}
+
+		delay := retryDelay(attempt, resp.Header.Get("Retry-After"))
+		slog.Warn("ChatGPT request failed, retrying", "status", resp.StatusCode, "attempt", attempt, "delay", delay)
+		err = sleepContext(ctx, delay)
+		if err != nil {
+			return "", err
+		}
+	}
+	defer resp.Body.Close()
+
+	// Errors come back as a plain JSON body even for streamed requests.
+	if c.Stream && resp.StatusCode == http.StatusOK {
+		content, err := c.readStream(resp.Body, model)
+		if err != nil {
+			return "", err
+		}
+		if content == "" {
+			return c.fallbackMessage(), nil
+		}
+		return content, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var apiResponse ChatGptResponse
+
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		if class := classifyStatus(resp.StatusCode); class != nil {
+			return "", fmt.Errorf("%w: chatgpt API status %d", class, resp.StatusCode)
+		}
+		return "", err
+	}
+
+	if apiResponse.Error != nil {
+		apiResponse.Error.StatusCode = resp.StatusCode
+		return "", apiResponse.Error
+	}
+
+	slog.Debug("Received ChatGPT response", "model", model, "choices", len(apiResponse.Choices))
+	c.addUsage(model, apiResponse.Usage)
+
+	if len(apiResponse.Choices) == 0 {
+		return c.fallbackMessage(), nil
+	}
+
+	return apiResponse.Choices[0].Message.Content, nil
+}
+
+func (c *HttpChatClient) maxAttempts() int {
+	if c.MaxAttempts > 0 {
+		return c.MaxAttempts
+	}
+	return DefaultChatGptMaxAttempts
+}
+
+func (c *HttpChatClient) fallbackMessage() string {
+	if c.FallbackMessage != "" {
+		return c.FallbackMessage
+	}
+	return DefaultFallbackMessage
+}
+
+// readStream assembles a streamed answer from server-sent events. Each
+// "data:" line carries a chunk whose delta.content is appended, until the
+// "data: [DONE]" sentinel.
+func (c *HttpChatClient) readStream(r io.Reader, model string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxStreamLineBytes)
+
+	var content strings.Builder
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			// Blank separators, ":" comments and other SSE fields.
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			slog.Debug("Received streamed ChatGPT response", "model", model, "length", content.Len())
+			return content.String(), nil
+		}
+
+		var chunk ChatGptStreamChunk
+		err := json.Unmarshal([]byte(data), &chunk)
+		if err != nil {
+			return "", fmt.Errorf("decoding chatgpt stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			chunk.Error.StatusCode = http.StatusOK
+			return "", chunk.Error
+		}
+		if chunk.Usage != nil {
+			c.addUsage(model, *chunk.Usage)
+		}
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("reading chatgpt stream: %w", err)
+	}
+	return "", fmt.Errorf("reading chatgpt stream: %w before [DONE]", io.ErrUnexpectedEOF)
+}
+
+func (c *HttpChatClient) addUsage(model string, usage ChatGptUsage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.usage == nil {
+		c.usage = map[string]ChatGptUsage{}
+	}
+	total := c.usage[model]
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	c.usage[model] = total
+}
+
+// Usage returns the tokens used by all Send calls so far, by model.
+func (c *HttpChatClient) Usage() map[string]ChatGptUsage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	usage := make(map[string]ChatGptUsage, len(c.usage))
+	for model, u := range c.usage {
+		usage[model] = u
+	}
+	return usage
+}
+
+// setAuthHeaders authenticates req for the configured backend.
+func (c *HttpChatClient) setAuthHeaders(req *http.Request) {
+	if c.ApiType == ApiTypeAzure {
+		req.Header.Set("api-key", c.ApiKey)
+		return
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.ApiKey))
+	if c.OrgId != "" {
+		req.Header.Set("OpenAI-Organization", c.OrgId)
+	}
+	if c.ProjectId != "" {
+		req.Header.Set("OpenAI-Project", c.ProjectId)
+	}
+}
+
+// Ping checks the API key without spending tokens: it looks up Model on
+// OpenAI, or lists the models of an Azure resource.
+func (c *HttpChatClient) Ping(ctx context.Context) error {
+	ctx, cancel := requestContext(ctx, c.Timeout)
+	defer cancel()
+	url := strings.TrimRight(c.BaseUrl, "/") + "/models/" + neturl.PathEscape(c.Model)
+	if c.ApiType == ApiTypeAzure {
+		url = fmt.Sprintf("%s/openai/models?api-version=%s",
+			strings.TrimRight(c.AzureEndpoint, "/"),
+			neturl.QueryEscape(c.AzureApiVersion))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	c.setAuthHeaders(req)
+
+	resp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrTransient, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var apiResponse ChatGptResponse
+	body, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(body, &apiResponse) == nil && apiResponse.Error != nil {
+		return fmt.Errorf("chatgpt API status %d: %s", resp.StatusCode, apiResponse.Error.Message)
+	}
+	return fmt.Errorf("chatgpt API status %d", resp.StatusCode)
+}
+
+// url returns the chat completions endpoint for the configured backend.
+func (c *HttpChatClient) url() string {
+	if c.ApiType != ApiTypeAzure {
+		return strings.TrimRight(c.BaseUrl, "/") + "/chat/completions"
+	}
+
+	return fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+		strings.TrimRight(c.AzureEndpoint, "/"),
+		neturl.PathEscape(c.AzureDeployment),
+		neturl.QueryEscape(c.AzureApiVersion))
+}
+
+// shouldFallback reports whether err means another model might succeed:
+// the model is overloaded, rate limited or does not exist.
+func shouldFallback(err error) bool {
+	if errors.Is(err, ErrTransient) || errors.Is(err, ErrRateLimit) || errors.Is(err, ErrChatGptRetriesExhausted) {
+		return true
+	}
+	var apiErr *ChatGptApiError
+	return errors.As(err, &apiErr) && (apiErr.Code == "model_not_found" || apiErr.StatusCode == http.StatusNotFound)
+}
+
+// isRetryableStatus reports whether an HTTP status is worth retrying:
+// rate limiting and server-side failures.
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// retryDelay returns how long to wait before the next attempt. A valid
+// Retry-After header wins; otherwise the delay doubles with each attempt
+// and gets up to a second of jitter.
+func retryDelay(attempt int, retryAfter string) time.Duration {
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	backoff := time.Duration(1<<(attempt-1)) * time.Second
+	return backoff + time.Duration(rand.Int63n(int64(time.Second)))
+}
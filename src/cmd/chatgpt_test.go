@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChatMessageMarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		message ChatMessage
+		want    string
+	}{
+		{
+			name:    "text only",
+			message: ChatMessage{Role: "user", Content: "hi"},
+			want:    `{"role":"user","content":"hi"}`,
+		},
+		{
+			name:    "with image",
+			message: ChatMessage{Role: "user", Content: "what is this?", Images: []string{"data:image/png;base64,AAAA"}},
+			want:    `{"content":[{"text":"what is this?","type":"text"},{"image_url":{"url":"data:image/png;base64,AAAA"},"type":"image_url"}],"role":"user"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.message)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Marshal() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/models/gpt-4o" {
+			t.Errorf("request = %s %s, want GET /models/gpt-4o", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer sk-good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"Incorrect API key provided","type":"invalid_request_error"}}`))
+			return
+		}
+		w.Write([]byte(`{"id":"gpt-4o","object":"model"}`))
+	}))
+	defer server.Close()
+
+	client := &HttpChatClient{HttpClient: server.Client(), BaseUrl: server.URL, Model: "gpt-4o", ApiKey: "sk-good"}
+	if err := client.Ping(context.Background()); err != nil {
+		t.Errorf("Ping() error = %v", err)
+	}
+
+	client.ApiKey = "sk-bad"
+	err := client.Ping(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Incorrect API key") {
+		t.Errorf("Ping() error = %v, want the API error message", err)
+	}
+}
+
+func TestUsageCostByModel(t *testing.T) {
+	client := &HttpChatClient{Model: "gpt-4o"}
+	client.addUsage("gpt-4o", ChatGptUsage{PromptTokens: 1000, CompletionTokens: 1000})
+	// A fallback model answered the rest, at its own price.
+	client.addUsage("gpt-4o-mini", ChatGptUsage{PromptTokens: 2000, CompletionTokens: 1000})
+	client.addUsage("gpt-4o-mini", ChatGptUsage{PromptTokens: 2000})
+
+	usage := client.Usage()
+	if got := usage["gpt-4o-mini"]; got.PromptTokens != 4000 || got.CompletionTokens != 1000 {
+		t.Errorf("Usage()[gpt-4o-mini] = %+v, want 4000 prompt and 1000 completion tokens", got)
+	}
+
+	usd, ok := usageCost(usage)
+	want := 0.005 + 0.015 + 4*0.00015 + 0.0006
+	if !ok || math.Abs(usd-want) > 1e-9 {
+		t.Errorf("usageCost() = %v, %v, want %v, true", usd, ok, want)
+	}
+
+	usage["unpriced-model"] = ChatGptUsage{PromptTokens: 1}
+	if _, ok := usageCost(usage); ok {
+		t.Error("usageCost() ok = true with a model of unknown price")
+	}
+}
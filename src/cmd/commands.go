@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	neturl "net/url"
+	"time"
+)
+
+const CommandThinkingMessage = "Thinking…"
+
+// SlashCommand holds the fields of a slash-command payload the bot uses.
+type SlashCommand struct {
+	Text        string
+	ChannelId   string
+	UserId      string
+	ResponseUrl string
+}
+
+// commandsHandler answers slash commands such as "/ask <question>", and
+// recaps threads for SummarizeCommand. Slack needs a response within 3
+// seconds, so the handler replies with CommandThinkingMessage at once and
+// posts the answer to the command's response_url when ChatGPT returns.
+func (r *runner) commandsHandler(ctx context.Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(req.Body, MaxEventBodyBytes))
+		if err != nil {
+			slog.Warn("Error reading slash command", "error", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !verifySlackRequest(r.cfg.SigningSecret, req.Header, body, time.Now()) {
+			slog.Warn("Rejected slash command with invalid signature", "remote_addr", req.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		form, err := neturl.ParseQuery(string(body))
+		if err != nil {
+			slog.Warn("Error decoding slash command", "error", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		command := SlashCommand{
+			Text:        form.Get("text"),
+			ChannelId:   form.Get("channel_id"),
+			UserId:      form.Get("user_id"),
+			ResponseUrl: form.Get("response_url"),
+		}
+
+		if form.Get("command") == SummarizeCommand {
+			channelId, threadTs, err := r.summarizeTarget(command)
+			if err != nil {
+				writeCommandResponse(w, "ephemeral", err.Error())
+				return
+			}
+			go r.summarizeCommand(ctx, command, channelId, threadTs)
+			writeCommandResponse(w, "ephemeral", CommandThinkingMessage)
+			return
+		}
+
+		if command.Text == "" {
+			writeCommandResponse(w, "ephemeral", fmt.Sprintf("Usage: %s <question>", form.Get("command")))
+			return
+		}
+
+		go r.answerCommand(ctx, command)
+		writeCommandResponse(w, "ephemeral", CommandThinkingMessage)
+	})
+}
+
+// answerCommand asks ChatGPT the command's question and posts the answer
+// to the channel through the command's response_url.
+func (r *runner) answerCommand(ctx context.Context, command SlashCommand) {
+	var history []ChatMessage
+	if r.cfg.MatchLanguage {
+		history = []ChatMessage{{Role: "system", Content: languageHint(command.Text)}}
+	}
+
+	start := time.Now()
+	prompt := r.cfg.renderPrompt(PromptData{Text: command.Text, User: command.UserId, Channel: command.ChannelId})
+	resp, err := r.cfg.Chat.Send(ctx, history, prompt, r.cfg.chatOptions(command.ChannelId))
+	chatGptLatency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		chatGptErrors.WithLabelValues(command.ChannelId).Inc()
+		slog.Error("Error sending slash command to ChatGPT", "channel", command.ChannelId, "user", command.UserId, "error", err)
+		err = r.cfg.Slack.RespondToCommand(ctx, command.ResponseUrl, map[string]interface{}{
+			"response_type": "ephemeral",
+			"text":          r.cfg.ErrorMessage,
+		})
+		if err != nil {
+			slog.Error("Error responding to slash command", "channel", command.ChannelId, "user", command.UserId, "error", err)
+		}
+		return
+	}
+
+	text := fmt.Sprintf("<@%s> asked: %s\n%s", command.UserId, command.Text, r.cfg.formatAnswer(resp))
+	err = r.cfg.Slack.RespondToCommand(ctx, command.ResponseUrl, map[string]interface{}{
+		"response_type": "in_channel",
+		"text":          text,
+	})
+	if err != nil {
+		slog.Error("Error responding to slash command", "channel", command.ChannelId, "user", command.UserId, "error", err)
+		slackErrors.WithLabelValues(command.ChannelId, "command").Inc()
+		return
+	}
+
+	slog.Info("Slash command answered", "channel", command.ChannelId, "user", command.UserId)
+	answersPosted.WithLabelValues(command.ChannelId).Inc()
+}
+
+func writeCommandResponse(w http.ResponseWriter, responseType, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"response_type": responseType,
+		"text":          text,
+	})
+}
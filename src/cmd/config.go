@@ -0,0 +1,610 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSlackTimeoutSeconds and DefaultOpenAITimeoutSeconds bound
+	// each HTTP request to the respective API when SLACK_TIMEOUT_SECONDS
+	// and OPENAI_TIMEOUT_SECONDS are not set.
+	DefaultSlackTimeoutSeconds  = 10
+	DefaultOpenAITimeoutSeconds = 120
+	// MaxIdleConnsPerHost keeps enough warm connections to Slack and
+	// OpenAI that repeated calls skip the TLS handshake.
+	MaxIdleConnsPerHost = 10
+)
+
+// DefaultTimezone is used when TIMEZONE is not set. It anchors the
+// default "since 20:00 yesterday" history window.
+const DefaultTimezone = "Asia/Tokyo"
+
+// Modes selected by SLACK_MODE.
+const (
+	// ModePoll reads channel history on each pass.
+	ModePoll = "poll"
+	// ModeEvents serves the Events API and slash commands over HTTP.
+	ModeEvents = "events"
+	// ModeSocket receives events over a Socket Mode WebSocket.
+	ModeSocket = "socket"
+)
+
+// LoadConfig reads the configuration from the environment, applies
+// defaults and validates it. Malformed optional values are logged and
+// replaced by their defaults; missing required values and malformed
+// values with no sensible default are returned as an error.
+func LoadConfig() (Config, error) {
+	return loadConfig(false)
+}
+
+// LoadHealthcheckConfig is LoadConfig for the healthcheck subcommand,
+// which needs only the Slack and OpenAI credentials: channels, the mode
+// and its secrets are not required.
+func LoadHealthcheckConfig() (Config, error) {
+	return loadConfig(true)
+}
+
+func loadConfig(credentialsOnly bool) (Config, error) {
+	slackToken, err := envSecret("SLACK_BOT_TOKEN")
+	if err != nil {
+		return Config{}, err
+	}
+	apiKey, err := envSecret("CHAT_GPT_API_KEY")
+	if err != nil {
+		return Config{}, err
+	}
+
+	transport := newTransport()
+	slack := &HttpSlackClient{
+		HttpClient:      &http.Client{Transport: transport},
+		Timeout:         DefaultSlackTimeoutSeconds * time.Second,
+		Token:           slackToken,
+		AppToken:        os.Getenv("SLACK_APP_TOKEN"),
+		BaseUrl:         os.Getenv("SLACK_API_BASE_URL"),
+		MaxHistoryPages: DefaultMaxHistoryPages,
+		MaxMessages:     DefaultMaxFetchMessages,
+		MaxRetries:      DefaultSlackMaxRetries,
+	}
+	chat := &HttpChatClient{
+		HttpClient:   &http.Client{Transport: transport},
+		Timeout:      DefaultOpenAITimeoutSeconds * time.Second,
+		ApiKey:       apiKey,
+		Model:        os.Getenv("OPENAI_MODEL"),
+		SystemPrompt: os.Getenv("SYSTEM_PROMPT"),
+		RedactPII:    envBool("REDACT_PII"),
+		// Streaming is the default; STREAM=false restores a single
+		// blocking response.
+		Stream: os.Getenv("STREAM") == "" || envBool("STREAM"),
+
+		ApiType:         strings.ToLower(os.Getenv("OPENAI_API_TYPE")),
+		BaseUrl:         os.Getenv("OPENAI_BASE_URL"),
+		AzureEndpoint:   os.Getenv("AZURE_OPENAI_ENDPOINT"),
+		AzureDeployment: os.Getenv("AZURE_OPENAI_DEPLOYMENT"),
+		AzureApiVersion: os.Getenv("AZURE_OPENAI_API_VERSION"),
+		FallbackMessage: os.Getenv("FALLBACK_MESSAGE"),
+		OrgId:           os.Getenv("OPENAI_ORG_ID"),
+		ProjectId:       os.Getenv("OPENAI_PROJECT_ID"),
+	}
+	if chat.Model == "" {
+		chat.Model = DefaultChatGptModel
+	}
+	chat.FallbackModels = splitCommaList(os.Getenv("OPENAI_MODEL_FALLBACKS"))
+	if chat.BaseUrl == "" {
+		chat.BaseUrl = DefaultOpenAIBaseUrl
+	}
+	if chat.ApiType == "" {
+		chat.ApiType = ApiTypeOpenAI
+	}
+	if chat.AzureApiVersion == "" {
+		chat.AzureApiVersion = DefaultAzureApiVersion
+	}
+
+	cfg := Config{
+		AnswerLimit:        AnswerLimit,
+		ReplyInterval:      DefaultReplyIntervalSeconds * time.Second,
+		SleepJitterPercent: DefaultSleepJitterPercent,
+		Concurrency:        1,
+		ThreadHistoryLimit: DefaultThreadHistoryLimit,
+		StateFile:          os.Getenv("STATE_FILE"),
+		Location:           loadLocation(os.Getenv("TIMEZONE")),
+		SigningSecret:      os.Getenv("SLACK_SIGNING_SECRET"),
+		SummaryChannelId:   os.Getenv("SUMMARY_CHANNEL_ID"),
+		Slack:              slack,
+		Chat:               chat,
+	}
+
+	cfg.ChannelIds = splitCommaList(os.Getenv("SLACK_CHANNEL_IDS"))
+	if len(cfg.ChannelIds) == 0 {
+		cfg.ChannelIds = splitCommaList(os.Getenv("SLACK_CHANNEL_ID"))
+	}
+
+	cfg.AllowedUsers = splitCommaList(os.Getenv("ALLOWED_USERS"))
+	cfg.IgnoredUsers = splitCommaList(os.Getenv("IGNORED_USERS"))
+
+	// Set but empty means no keyword matches, for channels that only
+	// answer questions matching QUESTION_REGEX or a channel's own keywords.
+	keywords, ok := os.LookupEnv("QUESTION_KEYWORDS")
+	if !ok {
+		keywords = DefaultQuestionKeywords
+	}
+	cfg.QuestionKeywords = splitCommaList(keywords)
+	cfg.IgnoreCodeBlocks = envBool("IGNORE_CODE_BLOCKS")
+
+	channels, err := loadChannelConfig(os.Getenv("CHANNEL_CONFIG"))
+	if err != nil {
+		return Config{}, fmt.Errorf("loading CHANNEL_CONFIG: %w", err)
+	}
+	cfg.Channels = channels
+
+	cfg.PromptTemplate, err = parsePromptTemplate(os.Getenv("PROMPT_TEMPLATE"))
+	if err != nil {
+		return Config{}, fmt.Errorf("parsing PROMPT_TEMPLATE: %w", err)
+	}
+
+	chat.FewShot, err = loadFewShot(os.Getenv("FEWSHOT_FILE"))
+	if err != nil {
+		return Config{}, fmt.Errorf("loading FEWSHOT_FILE: %w", err)
+	}
+
+	if pattern := os.Getenv("QUESTION_REGEX"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return Config{}, fmt.Errorf("compiling QUESTION_REGEX: %w", err)
+		}
+		cfg.QuestionRegex = re
+	}
+
+	if v := os.Getenv("ANSWER_LIMIT"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			slog.Warn("Invalid ANSWER_LIMIT, using default", "value", v)
+		} else if limit <= 0 {
+			slog.Warn("ANSWER_LIMIT must be positive, using default", "value", v)
+		} else {
+			cfg.AnswerLimit = limit
+		}
+	}
+
+	if v := os.Getenv("REPLY_INTERVAL_SECONDS"); v != "" {
+		interval, err := strconv.Atoi(v)
+		if err != nil || interval < 0 {
+			slog.Warn("Invalid REPLY_INTERVAL_SECONDS, using default", "value", v)
+		} else {
+			cfg.ReplyInterval = time.Duration(interval) * time.Second
+		}
+	}
+
+	if v := os.Getenv("MAX_AGE_MINUTES"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes <= 0 {
+			slog.Warn("Invalid MAX_AGE_MINUTES, ignoring", "value", v)
+		} else {
+			cfg.MaxAge = time.Duration(minutes) * time.Minute
+		}
+	}
+
+	if v := os.Getenv("MAX_REPLY_CHARS"); v != "" {
+		chars, err := strconv.Atoi(v)
+		if err != nil || chars <= 0 {
+			slog.Warn("Invalid MAX_REPLY_CHARS, ignoring", "value", v)
+		} else {
+			cfg.MaxReplyChars = chars
+		}
+	}
+
+	if v := os.Getenv("CONCURRENCY"); v != "" {
+		concurrency, err := strconv.Atoi(v)
+		if err != nil || concurrency <= 0 {
+			slog.Warn("Invalid CONCURRENCY, using default", "value", v)
+		} else {
+			cfg.Concurrency = concurrency
+		}
+	}
+
+	if v := os.Getenv("SLEEP_JITTER_PERCENT"); v != "" {
+		percent, err := strconv.Atoi(v)
+		if err != nil || percent < 0 || percent > 100 {
+			slog.Warn("Invalid SLEEP_JITTER_PERCENT, using default", "value", v)
+		} else {
+			cfg.SleepJitterPercent = percent
+		}
+	}
+
+	if v := os.Getenv("POLL_INTERVAL_SECONDS"); v != "" {
+		interval, err := strconv.Atoi(v)
+		if err != nil || interval <= 0 {
+			slog.Warn("Invalid POLL_INTERVAL_SECONDS, running once", "value", v)
+		} else {
+			cfg.PollInterval = time.Duration(interval) * time.Second
+		}
+	}
+
+	if v := os.Getenv("MAX_RUNTIME_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			slog.Warn("Invalid MAX_RUNTIME_SECONDS, running without a deadline", "value", v)
+		} else {
+			cfg.MaxRuntime = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if v := os.Getenv("SLACK_TIMEOUT_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			slog.Warn("Invalid SLACK_TIMEOUT_SECONDS, using default", "value", v)
+		} else {
+			slack.Timeout = time.Duration(seconds) * time.Second
+		}
+	}
+
+	if v := os.Getenv("OPENAI_TIMEOUT_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			slog.Warn("Invalid OPENAI_TIMEOUT_SECONDS, using default", "value", v)
+		} else {
+			chat.Timeout = time.Duration(seconds) * time.Second
+		}
+	}
+
+	chat.MaxAttempts = DefaultChatGptMaxAttempts
+	if v := os.Getenv("OPENAI_MAX_ATTEMPTS"); v != "" {
+		attempts, err := strconv.Atoi(v)
+		if err != nil || attempts <= 0 {
+			slog.Warn("Invalid OPENAI_MAX_ATTEMPTS, using default", "value", v)
+		} else {
+			chat.MaxAttempts = attempts
+		}
+	}
+
+	if v := os.Getenv("MAX_TOKENS"); v != "" {
+		maxTokens, err := strconv.Atoi(v)
+		if err != nil || maxTokens < 0 {
+			slog.Warn("Invalid MAX_TOKENS, ignoring", "value", v)
+		} else {
+			chat.MaxTokens = maxTokens
+		}
+	}
+
+	chat.Breaker = &CircuitBreaker{
+		Threshold: DefaultBreakerThreshold,
+		Cooldown:  DefaultBreakerCooldownSeconds * time.Second,
+	}
+	if v := os.Getenv("CIRCUIT_BREAKER_THRESHOLD"); v != "" {
+		threshold, err := strconv.Atoi(v)
+		if err != nil || threshold < 0 {
+			slog.Warn("Invalid CIRCUIT_BREAKER_THRESHOLD, using default", "value", v)
+		} else {
+			chat.Breaker.Threshold = threshold
+		}
+	}
+	if v := os.Getenv("CIRCUIT_BREAKER_COOLDOWN_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			slog.Warn("Invalid CIRCUIT_BREAKER_COOLDOWN_SECONDS, using default", "value", v)
+		} else {
+			chat.Breaker.Cooldown = time.Duration(seconds) * time.Second
+		}
+	}
+
+	chat.Temperature, err = envFloatRange("OPENAI_TEMPERATURE", 0, 2)
+	if err != nil {
+		return Config{}, err
+	}
+	chat.TopP, err = envFloatRange("OPENAI_TOP_P", 0, 1)
+	if err != nil {
+		return Config{}, err
+	}
+	if chat.Temperature != nil && chat.TopP != nil {
+		slog.Warn("Both OPENAI_TEMPERATURE and OPENAI_TOP_P are set; OpenAI recommends tuning only one")
+	}
+	chat.Stop = splitCommaList(os.Getenv("OPENAI_STOP"))
+	if len(chat.Stop) > MaxStopSequences {
+		return Config{}, fmt.Errorf("invalid OPENAI_STOP: %d sequences given, at most %d allowed", len(chat.Stop), MaxStopSequences)
+	}
+	chat.PresencePenalty, err = envFloatRange("OPENAI_PRESENCE_PENALTY", -2, 2)
+	if err != nil {
+		return Config{}, err
+	}
+	chat.FrequencyPenalty, err = envFloatRange("OPENAI_FREQUENCY_PENALTY", -2, 2)
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg.GreetByName = envBool("GREET_BY_NAME")
+	cfg.MatchLanguage = envBool("MATCH_LANGUAGE")
+	// Only ANSWER_IF_HUMAN_REPLIED=false skips threads a person replied to.
+	cfg.AnswerIfHumanReplied = envBoolDefault("ANSWER_IF_HUMAN_REPLIED", true)
+	cfg.UseBlockKit = envBool("USE_BLOCK_KIT")
+	cfg.ReplyInDm = envBool("REPLY_IN_DM")
+	cfg.OneReplyPerThread = envBool("ONE_REPLY_PER_THREAD")
+	cfg.EnableVision = envBool("ENABLE_VISION")
+	cfg.ReplyFooter = os.Getenv("REPLY_FOOTER")
+	cfg.JsonMode = envBool("JSON_MODE")
+	chat.JsonMode = cfg.JsonMode
+	cfg.ErrorMessage = envOrDefault("ON_ERROR_MESSAGE", DefaultErrorMessage)
+	cfg.OnError = strings.ToLower(envOrDefault("ON_ERROR", OnErrorSkip))
+	if cfg.OnError != OnErrorSkip && cfg.OnError != OnErrorNotify {
+		slog.Warn("Invalid ON_ERROR, using default", "value", cfg.OnError, "default", OnErrorSkip)
+		cfg.OnError = OnErrorSkip
+	}
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		cfg.Notifier = &Notifier{HttpClient: &http.Client{Transport: transport}, Url: url}
+	}
+	if cfg.EnableVision && !supportsVision(chat.Model) {
+		slog.Warn("ENABLE_VISION is set but the model does not accept images", "model", chat.Model)
+	}
+	cfg.Model = chat.Model
+
+	// EPHEMERAL_PREVIEW shows answers only to REVIEWER_USER_ID while a
+	// rollout is being reviewed.
+	if envBool("EPHEMERAL_PREVIEW") {
+		slack.EphemeralUser = os.Getenv("REVIEWER_USER_ID")
+		if slack.EphemeralUser == "" {
+			return Config{}, errors.New("REVIEWER_USER_ID is required when EPHEMERAL_PREVIEW is set")
+		}
+		cfg.Preview = true
+	}
+	// Reactions are visible to everyone, so a preview run adds none.
+	// Otherwise they are on, and an empty name turns one off.
+	if slack.EphemeralUser == "" {
+		cfg.ProcessingReaction = envOrDefaultIfUnset("PROCESSING_REACTION", DefaultProcessingReaction)
+		cfg.DoneReaction = envOrDefaultIfUnset("DONE_REACTION", DefaultDoneReaction)
+	}
+	// Ephemeral messages cannot be edited, so a preview run posts none.
+	cfg.UsePlaceholder = envBool("USE_PLACEHOLDER") && slack.EphemeralUser == ""
+	cfg.DryRun = envBool("DRY_RUN")
+	cfg.DryRunStubChatGpt = cfg.DryRun && envBool("DRY_RUN_STUB_CHATGPT")
+
+	if v := os.Getenv("THREAD_HISTORY_LIMIT"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			slog.Warn("Invalid THREAD_HISTORY_LIMIT, using default", "value", v)
+		} else {
+			cfg.ThreadHistoryLimit = limit
+		}
+	}
+
+	if v := os.Getenv("SLACK_MAX_RETRIES"); v != "" {
+		retries, err := strconv.Atoi(v)
+		if err != nil || retries < 0 {
+			slog.Warn("Invalid SLACK_MAX_RETRIES, using default", "value", v)
+		} else {
+			slack.MaxRetries = retries
+		}
+	}
+
+	// LOOKBACK_HOURS switches the history window from "since 20:00 JST
+	// yesterday" to "the last N hours", for runs on any cron cadence.
+	if v := os.Getenv("LOOKBACK_HOURS"); v != "" {
+		hours, err := strconv.ParseFloat(v, 64)
+		if err != nil || hours <= 0 {
+			slog.Warn("Invalid LOOKBACK_HOURS, using the default window", "value", v)
+		} else {
+			cfg.Lookback = time.Duration(hours * float64(time.Hour))
+		}
+	}
+
+	if v := os.Getenv("LATEST_OVERRIDE"); v != "" {
+		latest, err := parseTime(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid LATEST_OVERRIDE %q, expected RFC 3339 or Unix seconds: %w", v, err)
+		}
+		cfg.LatestOverride = latest
+	}
+
+	activeHours, err := parseActiveHours(os.Getenv("ACTIVE_HOURS_START"), os.Getenv("ACTIVE_HOURS_END"))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.ActiveHours = activeHours
+
+	if v := os.Getenv("SLACK_MAX_PAGES"); v != "" {
+		pages, err := strconv.Atoi(v)
+		if err != nil || pages <= 0 {
+			slog.Warn("Invalid SLACK_MAX_PAGES, using default", "value", v)
+		} else {
+			slack.MaxHistoryPages = pages
+		}
+	}
+
+	if v := os.Getenv("MAX_FETCH_MESSAGES"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			slog.Warn("Invalid MAX_FETCH_MESSAGES, using default", "value", v)
+		} else {
+			slack.MaxMessages = limit
+		}
+	}
+
+	cfg.Mode = strings.ToLower(envOrDefault("SLACK_MODE", ModePoll))
+	cfg.ServerAddr = ":" + envOrDefault("PORT", DefaultServerPort)
+
+	cfg.DebounceDelay = DefaultDebounceSeconds * time.Second
+	if v := os.Getenv("DEBOUNCE_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 {
+			slog.Warn("Invalid DEBOUNCE_SECONDS, using default", "value", v)
+		} else {
+			cfg.DebounceDelay = time.Duration(seconds) * time.Second
+		}
+	}
+
+	err = validateConfig(slack, chat, cfg, credentialsOnly)
+	if err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+
+}
+
+// newTransport returns the transport shared by the Slack and ChatGPT
+// clients, so connections are pooled and kept alive across calls.
+// Requests go through the proxy named by HTTPS_PROXY or HTTP_PROXY when
+// set, except for hosts listed in NO_PROXY.
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.MaxIdleConnsPerHost = MaxIdleConnsPerHost
+	return transport
+}
+
+// parseTime accepts either an RFC 3339 timestamp or Unix seconds, which
+// may be fractional like a Slack ts.
+func parseTime(v string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// loadLocation loads the named timezone, defaulting to DefaultTimezone.
+// The binary embeds time/tzdata, so any IANA name loads even without
+// system tzdata; an unknown name warns and falls back to UTC rather than
+// aborting the run.
+func loadLocation(name string) *time.Location {
+	if name == "" {
+		name = DefaultTimezone
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		slog.Warn("Could not load TIMEZONE, falling back to UTC", "timezone", name, "error", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// validateConfig reports every required environment variable that is
+// missing in a single error, so misconfiguration is fixed in one pass.
+// With credentialsOnly only what reaching Slack and OpenAI takes is
+// required.
+func validateConfig(slack *HttpSlackClient, chat *HttpChatClient, cfg Config, credentialsOnly bool) error {
+	var missing []string
+	if slack.Token == "" {
+		missing = append(missing, "SLACK_BOT_TOKEN (or SLACK_BOT_TOKEN_FILE)")
+	}
+	if chat.ApiKey == "" && !cfg.DryRunStubChatGpt {
+		missing = append(missing, "CHAT_GPT_API_KEY (or CHAT_GPT_API_KEY_FILE)")
+	}
+	if len(cfg.ChannelIds) == 0 && !credentialsOnly {
+		missing = append(missing, "SLACK_CHANNEL_ID (or SLACK_CHANNEL_IDS)")
+	}
+
+	switch {
+	case credentialsOnly:
+	case cfg.Mode == ModePoll:
+	case cfg.Mode == ModeEvents:
+		if cfg.SigningSecret == "" {
+			missing = append(missing, "SLACK_SIGNING_SECRET")
+		}
+	case cfg.Mode == ModeSocket:
+		if slack.AppToken == "" {
+			missing = append(missing, "SLACK_APP_TOKEN")
+		}
+	default:
+		return fmt.Errorf("unknown SLACK_MODE %q, expected %q, %q or %q", cfg.Mode, ModePoll, ModeEvents, ModeSocket)
+	}
+
+	switch chat.ApiType {
+	case ApiTypeOpenAI:
+	case ApiTypeAzure:
+		if chat.AzureEndpoint == "" {
+			missing = append(missing, "AZURE_OPENAI_ENDPOINT")
+		}
+		if chat.AzureDeployment == "" {
+			missing = append(missing, "AZURE_OPENAI_DEPLOYMENT")
+		}
+	default:
+		return fmt.Errorf("unknown OPENAI_API_TYPE %q, expected %q or %q", chat.ApiType, ApiTypeOpenAI, ApiTypeAzure)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// envOrDefault returns the named env var, or def when it is empty.
+func envOrDefault(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envOrDefaultIfUnset is like envOrDefault, except that a variable set
+// to the empty string yields "" rather than def.
+func envOrDefaultIfUnset(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return def
+}
+
+// envSecret returns the named secret, read from the file at name_FILE
+// when that is set, as with Docker and Kubernetes secrets, or else from
+// the variable itself. A trailing newline in the file is dropped.
+func envSecret(name string) (string, error) {
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return os.Getenv(name), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// envFloatRange parses the float in the named variable, which must lie
+// within [min, max]. It returns nil when the variable is unset, so the
+// API's default applies.
+func envFloatRange(name string, min, max float64) (*float64, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < min || f > max {
+		return nil, fmt.Errorf("invalid %s %q, must be between %g and %g", name, v, min, max)
+	}
+	return &f, nil
+}
+
+// envBool reports whether the named env var holds a truthy value such as
+// "1" or "true".
+func envBool(name string) bool {
+	v, err := strconv.ParseBool(os.Getenv(name))
+	return err == nil && v
+}
+
+// envBoolDefault is like envBool, but returns def when the variable is
+// unset or not a boolean.
+func envBoolDefault(name string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// splitCommaList splits a comma-separated env value, trimming whitespace
+// and dropping empty entries.
+func splitCommaList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
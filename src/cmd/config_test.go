@@ -0,0 +1,412 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("SLACK_BOT_TOKEN", "xoxb-test")
+	t.Setenv("CHAT_GPT_API_KEY", "sk-test")
+	t.Setenv("SLACK_CHANNEL_ID", "C123")
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if got := strings.Join(cfg.ChannelIds, ","); got != "C123" {
+		t.Errorf("ChannelIds = %q, want C123", got)
+	}
+	if cfg.AnswerLimit != AnswerLimit {
+		t.Errorf("AnswerLimit = %d, want %d", cfg.AnswerLimit, AnswerLimit)
+	}
+	if cfg.ReplyInterval != DefaultReplyIntervalSeconds*time.Second {
+		t.Errorf("ReplyInterval = %s, want %ds", cfg.ReplyInterval, DefaultReplyIntervalSeconds)
+	}
+	if cfg.Model != DefaultChatGptModel {
+		t.Errorf("Model = %q, want %q", cfg.Model, DefaultChatGptModel)
+	}
+	if cfg.Mode != ModePoll {
+		t.Errorf("Mode = %q, want %q", cfg.Mode, ModePoll)
+	}
+	if chat := cfg.Chat.(*HttpChatClient); chat.MaxAttempts != DefaultChatGptMaxAttempts {
+		t.Errorf("MaxAttempts = %d, want %d", chat.MaxAttempts, DefaultChatGptMaxAttempts)
+	}
+	if got := strings.Join(cfg.QuestionKeywords, ","); got != DefaultQuestionKeywords {
+		t.Errorf("QuestionKeywords = %q, want %q", got, DefaultQuestionKeywords)
+	}
+}
+
+func TestLoadConfigReactions(t *testing.T) {
+	tests := []struct {
+		name           string
+		env            map[string]string
+		wantProcessing string
+		wantDone       string
+	}{
+		{
+			name:           "defaults",
+			wantProcessing: DefaultProcessingReaction,
+			wantDone:       DefaultDoneReaction,
+		},
+		{
+			name:           "custom names",
+			env:            map[string]string{"PROCESSING_REACTION": "hourglass", "DONE_REACTION": "robot_face"},
+			wantProcessing: "hourglass",
+			wantDone:       "robot_face",
+		},
+		{
+			name:           "empty name disables",
+			env:            map[string]string{"PROCESSING_REACTION": ""},
+			wantProcessing: "",
+			wantDone:       DefaultDoneReaction,
+		},
+		{
+			name:           "none in preview",
+			env:            map[string]string{"EPHEMERAL_PREVIEW": "true", "REVIEWER_USER_ID": "U1"},
+			wantProcessing: "",
+			wantDone:       "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			cfg, err := LoadConfig()
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if cfg.ProcessingReaction != tt.wantProcessing || cfg.DoneReaction != tt.wantDone {
+				t.Errorf("reactions = %q, %q, want %q, %q", cfg.ProcessingReaction, cfg.DoneReaction, tt.wantProcessing, tt.wantDone)
+			}
+		})
+	}
+}
+
+func TestLoadConfigAnswerIfHumanReplied(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{value: "", want: true},
+		{value: "true", want: true},
+		{value: "false", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run("value "+tt.value, func(t *testing.T) {
+			setRequiredEnv(t)
+			if tt.value != "" {
+				t.Setenv("ANSWER_IF_HUMAN_REPLIED", tt.value)
+			}
+
+			cfg, err := LoadConfig()
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if cfg.AnswerIfHumanReplied != tt.want {
+				t.Errorf("AnswerIfHumanReplied = %v, want %v", cfg.AnswerIfHumanReplied, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigEmptyQuestionKeywords(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("QUESTION_KEYWORDS", "")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if len(cfg.QuestionKeywords) != 0 {
+		t.Errorf("QuestionKeywords = %q, want none when set but empty", cfg.QuestionKeywords)
+	}
+	if cfg.isQuestion(DefaultQuestionKeywords) {
+		t.Errorf("isQuestion(%q) = true, want false when QUESTION_KEYWORDS is empty", DefaultQuestionKeywords)
+	}
+}
+
+func TestLoadConfigOverrides(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("SLACK_CHANNEL_IDS", "C1, C2")
+	t.Setenv("ANSWER_LIMIT", "3")
+	t.Setenv("OPENAI_MODEL", "gpt-4o")
+	t.Setenv("REPLY_INTERVAL_SECONDS", "not-a-number")
+	t.Setenv("OPENAI_MAX_ATTEMPTS", "5")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if got := strings.Join(cfg.ChannelIds, ","); got != "C1,C2" {
+		t.Errorf("ChannelIds = %q, want C1,C2", got)
+	}
+	if cfg.AnswerLimit != 3 {
+		t.Errorf("AnswerLimit = %d, want 3", cfg.AnswerLimit)
+	}
+	if cfg.Model != "gpt-4o" {
+		t.Errorf("Model = %q, want gpt-4o", cfg.Model)
+	}
+	if cfg.ReplyInterval != DefaultReplyIntervalSeconds*time.Second {
+		t.Errorf("ReplyInterval = %s, want the default for a malformed value", cfg.ReplyInterval)
+	}
+	if chat := cfg.Chat.(*HttpChatClient); chat.MaxAttempts != 5 {
+		t.Errorf("MaxAttempts = %d, want 5", chat.MaxAttempts)
+	}
+
+	t.Setenv("OPENAI_MAX_ATTEMPTS", "0")
+	cfg, err = LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if chat := cfg.Chat.(*HttpChatClient); chat.MaxAttempts != DefaultChatGptMaxAttempts {
+		t.Errorf("MaxAttempts = %d, want the default for an invalid value", chat.MaxAttempts)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name:    "missing token",
+			env:     map[string]string{"SLACK_BOT_TOKEN": ""},
+			wantErr: "SLACK_BOT_TOKEN",
+		},
+		{
+			name:    "unreadable token file",
+			env:     map[string]string{"SLACK_BOT_TOKEN_FILE": "/nonexistent/slack-token"},
+			wantErr: "SLACK_BOT_TOKEN_FILE",
+		},
+		{
+			name:    "invalid temperature",
+			env:     map[string]string{"OPENAI_TEMPERATURE": "3"},
+			wantErr: "OPENAI_TEMPERATURE",
+		},
+		{
+			name:    "top_p out of range",
+			env:     map[string]string{"OPENAI_TOP_P": "1.5"},
+			wantErr: "OPENAI_TOP_P",
+		},
+		{
+			name:    "too many stop sequences",
+			env:     map[string]string{"OPENAI_STOP": "a,b,c,d,e"},
+			wantErr: "OPENAI_STOP",
+		},
+		{
+			name:    "presence penalty out of range",
+			env:     map[string]string{"OPENAI_PRESENCE_PENALTY": "2.5"},
+			wantErr: "OPENAI_PRESENCE_PENALTY",
+		},
+		{
+			name:    "frequency penalty not a number",
+			env:     map[string]string{"OPENAI_FREQUENCY_PENALTY": "high"},
+			wantErr: "OPENAI_FREQUENCY_PENALTY",
+		},
+		{
+			name:    "invalid question regex",
+			env:     map[string]string{"QUESTION_REGEX": "("},
+			wantErr: "QUESTION_REGEX",
+		},
+		{
+			name:    "unknown prompt template field",
+			env:     map[string]string{"PROMPT_TEMPLATE": "Q: {{.Question}}"},
+			wantErr: "PROMPT_TEMPLATE",
+		},
+		{
+			name:    "socket mode without app token",
+			env:     map[string]string{"SLACK_MODE": "socket"},
+			wantErr: "SLACK_APP_TOKEN",
+		},
+		{
+			name:    "unknown mode",
+			env:     map[string]string{"SLACK_MODE": "carrier-pigeon"},
+			wantErr: "unknown SLACK_MODE",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			_, err := LoadConfig()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadConfig() error = %v, want it to mention %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigChannelConfig(t *testing.T) {
+	setRequiredEnv(t)
+	path := filepath.Join(t.TempDir(), "channels.json")
+	err := os.WriteFile(path, []byte(`{"C1": {"model": "gpt-4o", "question_keywords": ["Q:"]}}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CHANNEL_CONFIG", path)
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if got := cfg.chatOptions("C1").Model; got != "gpt-4o" {
+		t.Errorf("chatOptions(C1).Model = %q, want gpt-4o", got)
+	}
+	if got := cfg.chatOptions("C2").Model; got != "" {
+		t.Errorf("chatOptions(C2).Model = %q, want empty", got)
+	}
+	if !cfg.forChannel("C1").isQuestion("Q: why?") {
+		t.Error("C1 did not use its own question keywords")
+	}
+	if !cfg.forChannel("C2").isQuestion(DefaultQuestionKeywords) {
+		t.Error("C2 did not fall back to the global question keywords")
+	}
+
+	t.Setenv("QUESTION_REGEX", `^help:`)
+	cfg, err = LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !cfg.forChannel("C1").isQuestion("Q: why?") {
+		t.Error("C1 did not use its own question keywords over QUESTION_REGEX")
+	}
+	if cfg.forChannel("C1").isQuestion("help: why?") {
+		t.Error("C1 used QUESTION_REGEX despite its own question keywords")
+	}
+	if !cfg.forChannel("C2").isQuestion("help: why?") {
+		t.Error("C2 did not fall back to QUESTION_REGEX")
+	}
+
+	err = os.WriteFile(path, []byte(`{"C1": {"modle": "gpt-4o"}}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), "CHANNEL_CONFIG") {
+		t.Errorf("LoadConfig() error = %v, want a CHANNEL_CONFIG error", err)
+	}
+}
+
+func TestLoadConfigFewShot(t *testing.T) {
+	setRequiredEnv(t)
+	path := filepath.Join(t.TempDir(), "fewshot.json")
+	err := os.WriteFile(path, []byte(`[
+		{"role": "user", "content": "質問です。デプロイ方法は？"},
+		{"role": "assistant", "content": "手順は3つです。"}
+	]`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FEWSHOT_FILE", path)
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	fewShot := cfg.Chat.(*HttpChatClient).FewShot
+	if len(fewShot) != 2 || fewShot[0].Role != "user" || fewShot[1].Content != "手順は3つです。" {
+		t.Errorf("FewShot = %+v", fewShot)
+	}
+
+	err = os.WriteFile(path, []byte(`[{"role": "bot", "content": "hi"}]`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), "invalid role") {
+		t.Errorf("LoadConfig() error = %v, want an invalid role error", err)
+	}
+}
+
+func TestLoadConfigSecretFiles(t *testing.T) {
+	setRequiredEnv(t)
+	dir := t.TempDir()
+	for name, secret := range map[string]string{"slack": "xoxb-from-file\n", "openai": "sk-from-file"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(secret), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("SLACK_BOT_TOKEN_FILE", filepath.Join(dir, "slack"))
+	t.Setenv("CHAT_GPT_API_KEY_FILE", filepath.Join(dir, "openai"))
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if got := cfg.Slack.(*HttpSlackClient).Token; got != "xoxb-from-file" {
+		t.Errorf("Slack token = %q, want xoxb-from-file", got)
+	}
+	if got := cfg.Chat.(*HttpChatClient).ApiKey; got != "sk-from-file" {
+		t.Errorf("API key = %q, want sk-from-file", got)
+	}
+}
+
+func TestLoadConfigPenalties(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	chat := cfg.Chat.(*HttpChatClient)
+	if chat.PresencePenalty != nil || chat.FrequencyPenalty != nil {
+		t.Errorf("penalties = %v, %v, want nil when unset", chat.PresencePenalty, chat.FrequencyPenalty)
+	}
+
+	t.Setenv("OPENAI_PRESENCE_PENALTY", "-2")
+	t.Setenv("OPENAI_FREQUENCY_PENALTY", "0.5")
+	cfg, err = LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	chat = cfg.Chat.(*HttpChatClient)
+	if chat.PresencePenalty == nil || *chat.PresencePenalty != -2 {
+		t.Errorf("PresencePenalty = %v, want -2", chat.PresencePenalty)
+	}
+	if chat.FrequencyPenalty == nil || *chat.FrequencyPenalty != 0.5 {
+		t.Errorf("FrequencyPenalty = %v, want 0.5", chat.FrequencyPenalty)
+	}
+}
+
+func TestLoadHealthcheckConfig(t *testing.T) {
+	t.Setenv("SLACK_BOT_TOKEN", "xoxb-test")
+	t.Setenv("CHAT_GPT_API_KEY", "sk-test")
+	t.Setenv("SLACK_CHANNEL_ID", "")
+	t.Setenv("SLACK_CHANNEL_IDS", "")
+	t.Setenv("SLACK_MODE", ModeEvents)
+	t.Setenv("SLACK_SIGNING_SECRET", "")
+
+	_, err := LoadHealthcheckConfig()
+	if err != nil {
+		t.Errorf("LoadHealthcheckConfig() error = %v, want only credentials required", err)
+	}
+	_, err = LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), "SLACK_CHANNEL_ID") || !strings.Contains(err.Error(), "SLACK_SIGNING_SECRET") {
+		t.Errorf("LoadConfig() error = %v, want channel and signing secret required", err)
+	}
+
+	t.Setenv("CHAT_GPT_API_KEY", "")
+	_, err = LoadHealthcheckConfig()
+	if err == nil || !strings.Contains(err.Error(), "CHAT_GPT_API_KEY") {
+		t.Errorf("LoadHealthcheckConfig() error = %v, want the API key required", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ModelPrice is what OpenAI charges, in USD per 1,000 tokens.
+type ModelPrice struct {
+	PromptPer1K     float64
+	CompletionPer1K float64
+}
+
+// ModelPrices lists list prices for the models the bot is commonly run
+// with. Dated snapshots match by prefix. PRICE_PROMPT_PER_1K and
+// PRICE_COMPLETION_PER_1K override them, e.g. for negotiated rates or
+// models missing here.
+var ModelPrices = map[string]ModelPrice{
+	"gpt-3.5-turbo": {PromptPer1K: 0.0005, CompletionPer1K: 0.0015},
+	"gpt-4":         {PromptPer1K: 0.03, CompletionPer1K: 0.06},
+	"gpt-4-32k":     {PromptPer1K: 0.06, CompletionPer1K: 0.12},
+	"gpt-4-turbo":   {PromptPer1K: 0.01, CompletionPer1K: 0.03},
+	"gpt-4o":        {PromptPer1K: 0.005, CompletionPer1K: 0.015},
+	"gpt-4o-mini":   {PromptPer1K: 0.00015, CompletionPer1K: 0.0006},
+}
+
+// priceFor returns the price of model, using the longest ModelPrices key
+// that prefixes it, with any env overrides applied. ok is false when
+// neither the table nor the env gives a price.
+func priceFor(model string) (price ModelPrice, ok bool) {
+	matched := 0
+	for name, p := range ModelPrices {
+		if strings.HasPrefix(model, name) && len(name) > matched {
+			price, matched, ok = p, len(name), true
+		}
+	}
+
+	if v, set := envPrice("PRICE_PROMPT_PER_1K"); set {
+		price.PromptPer1K = v
+		ok = true
+	}
+	if v, set := envPrice("PRICE_COMPLETION_PER_1K"); set {
+		price.CompletionPer1K = v
+		ok = true
+	}
+	return price, ok
+}
+
+// envPrice reads a non-negative price from the named env var.
+func envPrice(name string) (float64, bool) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, false
+	}
+	price, err := strconv.ParseFloat(v, 64)
+	if err != nil || price < 0 {
+		slog.Warn("Invalid price, ignoring", "name", name, "value", v)
+		return 0, false
+	}
+	return price, true
+}
+
+// logCost logs the tokens used so far by each model and, when its price
+// is known, what they cost. With several models it also logs the total,
+// with a cost only when every model's price is known.
+func logCost(usage map[string]ChatGptUsage) {
+	models := make([]string, 0, len(usage))
+	for model := range usage {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+
+	var total ChatGptUsage
+	for _, model := range models {
+		u := usage[model]
+		total.PromptTokens += u.PromptTokens
+		total.CompletionTokens += u.CompletionTokens
+		attrs := []any{"model", model, "prompt_tokens", u.PromptTokens, "completion_tokens", u.CompletionTokens}
+		if usd, ok := usageCost(map[string]ChatGptUsage{model: u}); ok {
+			attrs = append(attrs, "estimated_usd", strconv.FormatFloat(usd, 'f', 4, 64))
+		}
+		slog.Info("ChatGPT usage", attrs...)
+	}
+	if len(models) < 2 {
+		return
+	}
+
+	attrs := []any{"models", models, "prompt_tokens", total.PromptTokens, "completion_tokens", total.CompletionTokens}
+	if usd, ok := usageCost(usage); ok {
+		attrs = append(attrs, "estimated_usd", strconv.FormatFloat(usd, 'f', 4, 64))
+	}
+	slog.Info("ChatGPT total usage", attrs...)
+}
+
+// usageCost sums the cost of usage, pricing each model's tokens at that
+// model's price. ok is false when a model with usage has no known price.
+func usageCost(usage map[string]ChatGptUsage) (usd float64, ok bool) {
+	for model, u := range usage {
+		price, known := priceFor(model)
+		if !known {
+			return 0, false
+		}
+		usd += float64(u.PromptTokens)/1000*price.PromptPer1K + float64(u.CompletionTokens)/1000*price.CompletionPer1K
+	}
+	return usd, true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// debouncer runs a function once calls for its key have stopped for a
+// delay. A later call for the same key replaces the pending function.
+type debouncer struct {
+	delay time.Duration
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func newDebouncer(delay time.Duration) *debouncer {
+	return &debouncer{delay: delay, timers: map[string]*time.Timer{}}
+}
+
+// Debounce schedules fn to run after the delay, cancelling the function
+// pending for key, if any. With no delay, or a nil debouncer, fn runs at
+// once.
+func (d *debouncer) Debounce(key string, fn func()) {
+	if d == nil || d.delay <= 0 {
+		fn()
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if pending, ok := d.timers[key]; ok {
+		pending.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(d.delay, func() {
+		d.mu.Lock()
+		// A timer that fired while being replaced must not run too.
+		current := d.timers[key] == timer
+		if current {
+			delete(d.timers, key)
+		}
+		d.mu.Unlock()
+		if current {
+			fn()
+		}
+	})
+	d.timers[key] = timer
+}
+
+// Cancel drops the function pending for key, if any, without running it.
+func (d *debouncer) Cancel(key string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if pending, ok := d.timers[key]; ok {
+		pending.Stop()
+		delete(d.timers, key)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebounceRunsLastCallOnce(t *testing.T) {
+	d := newDebouncer(20 * time.Millisecond)
+	ran := make(chan string, 10)
+	for _, text := range []string{"質問で", "質問です", "質問です。Goについて"} {
+		text := text
+		d.Debounce("C1/1704150000.000100", func() { ran <- text })
+		time.Sleep(5 * time.Millisecond)
+	}
+	d.Debounce("C1/1704150001.000100", func() { ran <- "other" })
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case text := <-ran:
+			got[text] = true
+		case <-time.After(time.Second):
+			t.Fatal("debounced function did not run")
+		}
+	}
+	if !got["質問です。Goについて"] || !got["other"] {
+		t.Errorf("ran %v, want the final edit and the other message", got)
+	}
+
+	select {
+	case text := <-ran:
+		t.Errorf("superseded call ran with %q", text)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestEnqueueDropsDeletedMessage(t *testing.T) {
+	r := &runner{cfg: Config{ChannelIds: []string{"C1"}}, debounce: newDebouncer(20 * time.Millisecond)}
+	queue := make(chan SlackMessage, 10)
+
+	r.enqueue(queue, SlackEventEnvelope{Event: SlackMessage{Type: "message", Channel: "C1", User: "U1", Text: "質問です", Ts: "1.0"}})
+	r.enqueue(queue, SlackEventEnvelope{Event: SlackMessage{Type: "message", Channel: "C1", User: "U1", Text: "質問です", Ts: "3.0"}})
+	r.enqueue(queue, SlackEventEnvelope{Event: SlackMessage{
+		Type:      "message",
+		Subtype:   "message_deleted",
+		Hidden:    true,
+		Channel:   "C1",
+		Ts:        "2.0",
+		DeletedTs: "1.0",
+	}})
+
+	select {
+	case message := <-queue:
+		if message.Ts != "3.0" {
+			t.Errorf("queued %+v, want only the message that was not deleted", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing was queued")
+	}
+	select {
+	case message := <-queue:
+		t.Errorf("queued a second message %+v", message)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestEnqueueDebouncesEdits(t *testing.T) {
+	r := &runner{cfg: Config{ChannelIds: []string{"C1"}}, debounce: newDebouncer(20 * time.Millisecond)}
+	queue := make(chan SlackMessage, 10)
+
+	r.enqueue(queue, SlackEventEnvelope{Event: SlackMessage{Type: "message", Channel: "C1", User: "U1", Text: "質問", Ts: "1.0"}})
+	r.enqueue(queue, SlackEventEnvelope{Event: SlackMessage{
+		Type:    "message",
+		Subtype: "message_changed",
+		Hidden:  true,
+		Channel: "C1",
+		Ts:      "2.0",
+		Message: &SlackMessage{Type: "message", User: "U1", Text: "質問です", Ts: "1.0"},
+	}})
+
+	select {
+	case message := <-queue:
+		if message.Text != "質問です" || message.Channel != "C1" || message.Ts != "1.0" || message.Hidden {
+			t.Errorf("queued %+v, want the edited message", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing was queued")
+	}
+	select {
+	case message := <-queue:
+		t.Errorf("queued a second message %+v", message)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error classes for Slack and ChatGPT failures, matched with errors.Is.
+// ErrAuth means retrying is pointless until the configuration is fixed;
+// ErrRateLimit and ErrTransient may succeed on a later attempt.
+var (
+	ErrAuth      = errors.New("authentication failed")
+	ErrRateLimit = errors.New("rate limited")
+	ErrTransient = errors.New("transient failure")
+)
+
+// classifyStatus maps an HTTP status to an error class, or nil when the
+// status says nothing about whether a retry could help.
+func classifyStatus(code int) error {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return ErrAuth
+	case code == http.StatusTooManyRequests:
+		return ErrRateLimit
+	case code >= 500:
+		return ErrTransient
+	}
+	return nil
+}
+
+// errorClass names err's class for logs.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrAuth):
+		return "auth"
+	case errors.Is(err, ErrRateLimit):
+		return "rate_limit"
+	case errors.Is(err, ErrTransient):
+		return "transient"
+	}
+	return "permanent"
+}
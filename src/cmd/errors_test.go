@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"slack invalid_auth", &SlackApiError{Code: "invalid_auth"}, "auth"},
+		{"slack channel_not_found", &SlackApiError{Code: "channel_not_found"}, "permanent"},
+		{"slack internal_error", &SlackApiError{Code: "internal_error"}, "transient"},
+		{"slack 429", &RateLimitError{}, "rate_limit"},
+		{"slack 503", &SlackHttpError{StatusCode: 503}, "transient"},
+		{"chatgpt 401", &ChatGptApiError{StatusCode: 401}, "auth"},
+		{"chatgpt 400", &ChatGptApiError{StatusCode: 400}, "permanent"},
+		{"wrapped", fmt.Errorf("channel C1: %w", &SlackApiError{Code: "token_revoked"}), "auth"},
+		{"plain", errors.New("boom"), "permanent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := errorClass(tt.err)
+			if got != tt.want {
+				t.Errorf("errorClass(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
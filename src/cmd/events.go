@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultServerPort     = "8080"
+	EventQueueSize        = 100
+	ServerShutdownTimeout = 10 * time.Second
+	// SlackRequestMaxAge bounds how old a signed request may be, so a
+	// captured request can't be replayed later.
+	SlackRequestMaxAge = 5 * time.Minute
+	MaxEventBodyBytes  = 1 << 20
+	// DefaultDebounceSeconds is the quiet period before answering an
+	// event-driven question when DEBOUNCE_SECONDS is not set.
+	DefaultDebounceSeconds = 3
+)
+
+// SlackEventEnvelope is the outer payload Slack POSTs to the Events API
+// request URL. Event is only set for "event_callback" envelopes.
+type SlackEventEnvelope struct {
+	Type      string       `json:"type"`
+	Challenge string       `json:"challenge"`
+	EventId   string       `json:"event_id"`
+	Event     SlackMessage `json:"event"`
+}
+
+// Serve answers questions as Slack delivers message events instead of
+// polling channel history, and answers slash commands posted to
+// /slack/commands. It blocks until ctx is cancelled.
+func Serve(ctx context.Context, cfg Config) error {
+	r, err := newRunner(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	queue := make(chan SlackMessage, EventQueueSize)
+	mux := http.NewServeMux()
+	mux.Handle("/slack/events", r.eventsHandler(queue))
+	mux.Handle("/slack/commands", r.commandsHandler(ctx))
+	server := &http.Server{Addr: cfg.ServerAddr, Handler: mux}
+
+	go r.work(ctx, queue)
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ServerShutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			slog.Error("Error shutting down events server", "error", err)
+		}
+	}()
+
+	slog.Info("Listening for Slack events", "addr", cfg.ServerAddr)
+	err = server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("events server: %w", err)
+	}
+	return nil
+}
+
+func (r *runner) eventsHandler(queue chan<- SlackMessage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(req.Body, MaxEventBodyBytes))
+		if err != nil {
+			slog.Warn("Error reading Slack event", "error", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !verifySlackRequest(r.cfg.SigningSecret, req.Header, body, time.Now()) {
+			slog.Warn("Rejected Slack event with invalid signature", "remote_addr", req.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var envelope SlackEventEnvelope
+		err = json.Unmarshal(body, &envelope)
+		if err != nil {
+			slog.Warn("Error decoding Slack event", "error", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch envelope.Type {
+		case "url_verification":
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, envelope.Challenge)
+			return
+		case "event_callback":
+		default:
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// Slack redelivers events it thinks we missed; the first delivery
+		// was already queued, so a retry would produce a second answer.
+		if req.Header.Get("X-Slack-Retry-Num") != "" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		r.enqueue(queue, envelope)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// enqueue hands a message event in a watched channel to the worker once
+// it has gone DebounceDelay without an edit. An edit replaces the pending
+// message, so only its final text is answered, and deleting the message
+// before then means it is not answered at all. It never blocks: Slack
+// expects an ack within 3 seconds, so events that arrive while the queue
+// is full are dropped.
+func (r *runner) enqueue(queue chan<- SlackMessage, envelope SlackEventEnvelope) {
+	message := envelope.Event
+	if message.Type != "message" || !r.watchesChannel(message.Channel) {
+		return
+	}
+	if message.Subtype == "message_deleted" {
+		r.debounce.Cancel(message.Channel + "/" + message.DeletedTs)
+		return
+	}
+	if message.Subtype == "message_changed" && message.Message != nil {
+		edited := *message.Message
+		edited.Type = "message"
+		edited.Channel = message.Channel
+		message = edited
+	}
+
+	r.debounce.Debounce(message.Channel+"/"+message.Ts, func() {
+		select {
+		case queue <- message:
+		default:
+			slog.Warn("Event queue full, dropping message", "channel", message.Channel, "ts", message.Ts, "event_id", envelope.EventId)
+		}
+	})
+}
+
+// work answers queued messages one at a time. A single worker keeps
+// ChatGPT and Slack calls as serialized as they are in polling mode, and
+// keeps the answered-set owned by one goroutine.
+func (r *runner) work(ctx context.Context, queue <-chan SlackMessage) {
+	for message := range queue {
+		if !r.shouldAnswer(message.Channel, message) {
+			continue
+		}
+		questionsDetected.WithLabelValues(message.Channel).Inc()
+		if !r.cfg.ActiveHours.Contains(time.Now().In(r.cfg.Location)) {
+			slog.Info("Outside active hours, not answering", "channel", message.Channel, "ts", message.Ts)
+			continue
+		}
+		r.answer(ctx, message.Channel, message)
+	}
+}
+
+// watchesChannel reports whether channelId is one of the configured channels.
+func (r *runner) watchesChannel(channelId string) bool {
+	for _, id := range r.cfg.ChannelIds {
+		if id == channelId {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySlackRequest checks the signature headers and that the request
+// was signed within SlackRequestMaxAge of now.
+func verifySlackRequest(secret string, header http.Header, body []byte, now time.Time) bool {
+	ts := header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > SlackRequestMaxAge || age < -SlackRequestMaxAge {
+		return false
+	}
+	return verifySlackSignature(secret, ts, header.Get("X-Slack-Signature"), body)
+}
+
+// verifySlackSignature reports whether sig is the v0 HMAC-SHA256 signature
+// of "v0:{ts}:{body}" under secret, as documented at
+// https://api.slack.com/authentication/verifying-requests-from-slack.
+func verifySlackSignature(secret string, ts string, sig string, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(sig))
+}
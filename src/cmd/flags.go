@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// cliFlags holds the command-line overrides for ad-hoc runs. A flag only
+// takes effect when it was passed, so env-driven deployments behave the
+// same with no flags.
+type cliFlags struct {
+	channel string
+	model   string
+	limit   int
+	dryRun  bool
+	once    bool
+	serve   bool
+	// backfill answers the question in threadTs of channel and exits.
+	backfill bool
+	threadTs string
+	// healthcheck checks the Slack and OpenAI credentials and exits.
+	healthcheck bool
+
+	set map[string]bool
+}
+
+// parseFlags parses args, which may start or end with the "server",
+// "backfill" or "healthcheck" subcommand. It exits with usage on -h or a
+// bad flag.
+func parseFlags(args []string) cliFlags {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] [server]\n", fs.Name())
+		fmt.Fprintf(fs.Output(), "       %s backfill -channel C123 -thread-ts 1704150000.000100\n", fs.Name())
+		fmt.Fprintf(fs.Output(), "       %s healthcheck\n\n", fs.Name())
+		fmt.Fprintln(fs.Output(), "Answers Slack questions with ChatGPT. Configuration comes from environment")
+		fmt.Fprintln(fs.Output(), "variables; flags override them for a single run.")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+
+	var f cliFlags
+	fs.StringVar(&f.channel, "channel", "", "comma-separated Slack channel IDs (overrides SLACK_CHANNEL_IDS)")
+	fs.StringVar(&f.model, "model", "", "ChatGPT model (overrides OPENAI_MODEL)")
+	fs.IntVar(&f.limit, "limit", 0, "maximum answers per channel per pass (overrides ANSWER_LIMIT)")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "log replies instead of posting them (overrides DRY_RUN)")
+	fs.BoolVar(&f.once, "once", false, "answer one pass and exit (overrides POLL_INTERVAL_SECONDS)")
+	fs.BoolVar(&f.serve, "serve", false, "run the Events API server, same as the server subcommand")
+	fs.StringVar(&f.threadTs, "thread-ts", "", "thread to answer with the backfill subcommand")
+
+	subcommand := func(name string) {
+		switch name {
+		case "server":
+			f.serve = true
+		case "backfill":
+			f.backfill = true
+		case "healthcheck":
+			f.healthcheck = true
+		}
+	}
+	isSubcommand := func(arg string) bool {
+		return arg == "server" || arg == "backfill" || arg == "healthcheck"
+	}
+	if len(args) > 0 && isSubcommand(args[0]) {
+		subcommand(args[0])
+		args = args[1:]
+	}
+	fs.Parse(args)
+	if isSubcommand(fs.Arg(0)) && fs.NArg() == 1 {
+		subcommand(fs.Arg(0))
+	} else if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f.set = map[string]bool{}
+	fs.Visit(func(fl *flag.Flag) { f.set[fl.Name] = true })
+
+	if f.once && f.serve {
+		fmt.Fprintln(fs.Output(), "-once and -serve cannot be combined")
+		os.Exit(2)
+	}
+	if f.backfill && (f.serve || f.once) {
+		fmt.Fprintln(fs.Output(), "backfill cannot be combined with -serve or -once")
+		os.Exit(2)
+	}
+	if f.healthcheck && (f.serve || f.once || f.backfill) {
+		fmt.Fprintln(fs.Output(), "healthcheck cannot be combined with other modes")
+		os.Exit(2)
+	}
+	if f.backfill && (f.channel == "" || f.threadTs == "") {
+		fmt.Fprintln(fs.Output(), "backfill needs -channel and -thread-ts")
+		os.Exit(2)
+	}
+	if f.set["limit"] && f.limit <= 0 {
+		fmt.Fprintln(fs.Output(), "-limit must be positive")
+		os.Exit(2)
+	}
+	return f
+}
+
+// applyToEnv sets the env vars the passed flags override, so LoadConfig
+// sees a single source of configuration.
+func (f cliFlags) applyToEnv() {
+	if f.set["channel"] {
+		os.Setenv("SLACK_CHANNEL_IDS", f.channel)
+	}
+	if f.set["model"] {
+		os.Setenv("OPENAI_MODEL", f.model)
+	}
+	if f.set["limit"] {
+		os.Setenv("ANSWER_LIMIT", strconv.Itoa(f.limit))
+	}
+	if f.set["dry-run"] {
+		os.Setenv("DRY_RUN", strconv.FormatBool(f.dryRun))
+	}
+	if f.once {
+		os.Unsetenv("POLL_INTERVAL_SECONDS")
+	}
+	if f.serve {
+		os.Setenv("SLACK_MODE", ModeEvents)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// SlackMessageLimit is the longest text posted in a single Slack
+	// message; longer replies are split with splitMessage.
+	SlackMessageLimit = 4000
+	// SlackSectionTextLimit is the longest text a Block Kit section block
+	// accepts.
+	SlackSectionTextLimit = 3000
+
+	codeFence = "```"
+
+	// TruncatedSuffix ends a reply cut short by MAX_REPLY_CHARS.
+	TruncatedSuffix = "…(truncated)"
+)
+
+// formatAnswer turns ChatGPT's answer into Slack text: JSON mode answers
+// are shown as a code block, others have their Markdown converted.
+func (cfg Config) formatAnswer(text string) string {
+	if cfg.JsonMode {
+		return formatJson(text)
+	}
+	return markdownToSlack(text)
+}
+
+// formatJson pretty-prints text in a code block. Text that is not valid
+// JSON is fenced as is.
+func formatJson(text string) string {
+	text = strings.TrimSpace(text)
+	var indented bytes.Buffer
+	if json.Indent(&indented, []byte(text), "", "  ") == nil {
+		text = indented.String()
+	}
+	return codeFence + "\n" + text + "\n" + codeFence
+}
+
+// appendFooter adds footer on its own line to the last chunk, or as a
+// chunk of its own when the last chunk has no room for it within limit.
+// An empty footer leaves chunks unchanged.
+func appendFooter(chunks []string, footer string, limit int) []string {
+	if footer == "" || len(chunks) == 0 {
+		return chunks
+	}
+	last := chunks[len(chunks)-1] + "\n" + footer
+	if utf8.RuneCountInString(last) <= limit {
+		chunks[len(chunks)-1] = last
+		return chunks
+	}
+	return append(chunks, splitMessage(footer, limit)...)
+}
+
+// splitMessage breaks text into chunks of at most limit characters. It
+// prefers paragraph breaks, then line breaks, and hard-splits only when a
+// single line is too long. Splits are kept outside code blocks where
+// possible; when a block must be split, it is closed at the end of one
+// chunk and reopened at the start of the next.
+func splitMessage(text string, limit int) []string {
+	// Leave room to close a code block that has to be split.
+	budget := limit - len("\n"+codeFence)
+	if budget < 1 {
+		budget = limit
+	}
+
+	var chunks []string
+	for utf8.RuneCountInString(text) > limit {
+		cut := splitPoint(text, budget)
+		chunk := strings.TrimRight(text[:cut], "\n")
+		text = strings.TrimLeft(text[cut:], "\n")
+
+		if strings.Count(chunk, codeFence)%2 == 1 && budget < limit {
+			chunk += "\n" + codeFence
+			text = codeFence + "\n" + text
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// splitPoint returns the byte offset at which to cut text so the first
+// part has at most budget characters.
+func splitPoint(text string, budget int) int {
+	limit := len(text)
+	for i := range text {
+		if budget == 0 {
+			limit = i
+			break
+		}
+		budget--
+	}
+	head := text[:limit]
+
+	outsideCode := func(i int) bool {
+		return strings.Count(head[:i], codeFence)%2 == 0
+	}
+
+	for _, sep := range []string{"\n\n", "\n"} {
+		for i := strings.LastIndex(head, sep); i > 0; i = strings.LastIndex(head[:i], sep) {
+			if outsideCode(i) {
+				return i + len(sep)
+			}
+		}
+	}
+
+	if i := strings.LastIndex(head, "\n"); i > 0 {
+		return i + 1
+	}
+	return limit
+}
+
+// replyBlocks lays out a reply chunk as Block Kit: a mrkdwn section with
+// the text, so <@user> mentions still render, and, when model is set, a
+// context block naming the model that answered.
+func replyBlocks(text, model string) []map[string]interface{} {
+	blocks := []map[string]interface{}{
+		{
+			"type": "section",
+			"text": map[string]interface{}{
+				"type": "mrkdwn",
+				"text": text,
+			},
+		},
+	}
+
+	if model != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "context",
+			"elements": []map[string]interface{}{
+				{
+					"type": "mrkdwn",
+					"text": fmt.Sprintf("Answered by %s", model),
+				},
+			},
+		})
+	}
+	return blocks
+}
+
+// boldMark temporarily stands in for Slack's bold "*" so that the italic
+// rewrite does not mistake it for Markdown emphasis.
+const boldMark = "\x01"
+
+var (
+	mdHeading = regexp.MustCompile(`(?m)^#{1,6}[ \t]+(.+?)[ \t#]*$`)
+	mdBullet  = regexp.MustCompile(`(?m)^([ \t]*)[-*+][ \t]+`)
+	mdLink    = regexp.MustCompile(`\[([^\]\n]+)\]\(([^)\s]+)\)`)
+	mdBold    = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	// mdItalic needs text right inside both asterisks, so arithmetic
+	// such as 2 * 3 * 4 is left alone.
+	mdItalic = regexp.MustCompile(`\*([^*\s](?:[^*\n]*[^*\s])?)\*`)
+	mdStrike = regexp.MustCompile(`~~(.+?)~~`)
+)
+
+// markdownToSlack rewrites the GitHub-flavored Markdown ChatGPT tends to
+// produce into Slack mrkdwn: bold, italic, strikethrough, links, headings
+// (rendered bold) and bullet lists. Code blocks and inline code are left
+// untouched.
+func markdownToSlack(s string) string {
+	parts := strings.Split(s, codeFence)
+	for i := 0; i < len(parts); i += 2 {
+		parts[i] = markdownProseToSlack(parts[i])
+	}
+	return strings.Join(parts, codeFence)
+}
+
+// markdownProseToSlack converts text that lies outside code blocks,
+// skipping over inline code spans.
+func markdownProseToSlack(s string) string {
+	parts := strings.Split(s, "`")
+	for i := 0; i < len(parts); i += 2 {
+		text := parts[i]
+		text = mdHeading.ReplaceAllStringFunc(text, func(m string) string {
+			title := mdHeading.FindStringSubmatch(m)[1]
+			return boldMark + strings.ReplaceAll(title, "**", "") + boldMark
+		})
+		text = mdBullet.ReplaceAllString(text, "${1}• ")
+		text = mdLink.ReplaceAllString(text, "<$2|$1>")
+		text = mdBold.ReplaceAllString(text, boldMark+"$1$2"+boldMark)
+		text = mdItalic.ReplaceAllString(text, "_${1}_")
+		text = mdStrike.ReplaceAllString(text, "~$1~")
+		parts[i] = strings.ReplaceAll(text, boldMark, "*")
+	}
+	return strings.Join(parts, "`")
+}
+
+// truncateReply cuts text to at most limit characters, suffix included,
+// ending it with TruncatedSuffix. A code block left open by the cut is
+// closed first. It reports whether anything was cut; a limit of zero or
+// less disables the cap.
+func truncateReply(text string, limit int) (string, bool) {
+	if limit <= 0 || utf8.RuneCountInString(text) <= limit {
+		return text, false
+	}
+
+	suffix := "\n" + TruncatedSuffix
+	closing := "\n" + codeFence
+	runes := []rune(text)
+	keep := max(limit-utf8.RuneCountInString(suffix), 0)
+	// Trailing backticks may be part of a fence the cut went through.
+	cut := strings.TrimRight(string(runes[:keep]), "`")
+	if strings.Count(cut, codeFence)%2 == 1 {
+		// Make room for the closing fence, without leaving half of one.
+		cut = strings.TrimRight(string(runes[:max(keep-len(closing), 0)]), "`")
+		if strings.Count(cut, codeFence)%2 == 1 {
+			cut += closing
+		}
+	}
+	return cut + suffix, true
+}
@@ -0,0 +1,135 @@
+package main
+
+import "testing"
+
+func TestMarkdownToSlack(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "bold",
+			in:   "This is **important** and __so is this__.",
+			want: "This is *important* and *so is this*.",
+		},
+		{
+			name: "italic",
+			in:   "This is *slightly* emphasized, *as is this phrase*.",
+			want: "This is _slightly_ emphasized, _as is this phrase_.",
+		},
+		{
+			name: "bold and italic together",
+			in:   "**bold** then *italic*",
+			want: "*bold* then _italic_",
+		},
+		{
+			name: "asterisks in arithmetic",
+			in:   "2 * 3 * 4 = 24",
+			want: "2 * 3 * 4 = 24",
+		},
+		{
+			name: "pointer syntax in prose",
+			in:   "Assign *p = *q to copy the value.",
+			want: "Assign *p = *q to copy the value.",
+		},
+		{
+			name: "link",
+			in:   "See [the docs](https://go.dev/doc/) for details.",
+			want: "See <https://go.dev/doc/|the docs> for details.",
+		},
+		{
+			name: "bullet list",
+			in:   "- first\n* second\n  + nested",
+			want: "• first\n• second\n  • nested",
+		},
+		{
+			name: "heading",
+			in:   "## Setup\nRun it.",
+			want: "*Setup*\nRun it.",
+		},
+		{
+			name: "strikethrough",
+			in:   "~~old~~ new",
+			want: "~old~ new",
+		},
+		{
+			name: "code fence left untouched",
+			in:   "Use **this**:\n```\nx := **p * *q\n- not a bullet\n```\nDone *now*.",
+			want: "Use *this*:\n```\nx := **p * *q\n- not a bullet\n```\nDone _now_.",
+		},
+		{
+			name: "inline code left untouched",
+			in:   "Call `a *b* c` or **not**.",
+			want: "Call `a *b* c` or *not*.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := markdownToSlack(tt.in); got != tt.want {
+				t.Errorf("markdownToSlack(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateReply(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		limit         int
+		want          string
+		wantTruncated bool
+	}{
+		{
+			name:  "short enough",
+			text:  "short answer",
+			limit: 100,
+			want:  "short answer",
+		},
+		{
+			name:  "no limit",
+			text:  "any length at all",
+			limit: 0,
+			want:  "any length at all",
+		},
+		{
+			name:          "prose",
+			text:          "abcdefghijklmnopqrstuvwxyz",
+			limit:         20,
+			want:          "abcdefg\n" + TruncatedSuffix,
+			wantTruncated: true,
+		},
+		{
+			name:          "cut inside the opening fence",
+			text:          "```\ncode code code code\n```",
+			limit:         15,
+			want:          "\n" + TruncatedSuffix,
+			wantTruncated: true,
+		},
+		{
+			name:          "cut inside a code block",
+			text:          "Try:\n```\nfmt.Println(\"hello, world\")\nfmt.Println(\"again\")\n```",
+			limit:         40,
+			want:          "Try:\n```\nfmt.Println(\"h\n```\n" + TruncatedSuffix,
+			wantTruncated: true,
+		},
+		{
+			name:          "cut inside the closing fence",
+			text:          "```\nx\n```\nand then some more prose",
+			limit:         21,
+			want:          "```\n\n```\n" + TruncatedSuffix,
+			wantTruncated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated := truncateReply(tt.text, tt.limit)
+			if got != tt.want || truncated != tt.wantTruncated {
+				t.Errorf("truncateReply(%q, %d) = %q, %v, want %q, %v", tt.text, tt.limit, got, truncated, tt.want, tt.wantTruncated)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrHealthcheckFailed is returned by Healthcheck when any check fails.
+var ErrHealthcheckFailed = errors.New("healthcheck failed")
+
+// Healthcheck verifies the Slack token with auth.test and the OpenAI key
+// with a models lookup, printing OK or FAIL with the reason for each. It
+// returns ErrHealthcheckFailed if any check fails.
+func Healthcheck(ctx context.Context, cfg Config) error {
+	failed := false
+	report := func(name, detail string, err error) {
+		if err != nil {
+			failed = true
+			fmt.Fprintf(os.Stdout, "%-7s FAIL  %v (%s)\n", name, err, errorClass(err))
+			return
+		}
+		fmt.Fprintf(os.Stdout, "%-7s OK    %s\n", name, detail)
+	}
+
+	botUserId, err := cfg.Slack.FetchBotUserId(ctx)
+	report("Slack", "bot user "+botUserId, err)
+
+	if chat, ok := cfg.Chat.(*HttpChatClient); ok {
+		err = chat.Ping(ctx)
+		report("OpenAI", "model "+chat.Model, err)
+	}
+
+	if failed {
+		return ErrHealthcheckFailed
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ActiveHours is a daily window, in minutes after midnight, during which
+// the bot answers. End before Start means the window wraps past midnight,
+// e.g. 22:00 to 06:00.
+type ActiveHours struct {
+	Start int
+	End   int
+}
+
+// Contains reports whether t's wall-clock time falls inside the window.
+// Start is inclusive and End exclusive. A nil window contains every time.
+func (h *ActiveHours) Contains(t time.Time) bool {
+	if h == nil {
+		return true
+	}
+	m := t.Hour()*60 + t.Minute()
+	if h.Start <= h.End {
+		return m >= h.Start && m < h.End
+	}
+	return m >= h.Start || m < h.End
+}
+
+// parseActiveHours builds a window from ACTIVE_HOURS_START/END values in
+// "15" or "15:30" form. Both empty means no window.
+func parseActiveHours(start, end string) (*ActiveHours, error) {
+	if start == "" && end == "" {
+		return nil, nil
+	}
+	if start == "" || end == "" {
+		return nil, errors.New("ACTIVE_HOURS_START and ACTIVE_HOURS_END must be set together")
+	}
+
+	s, err := parseClock(start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACTIVE_HOURS_START: %w", err)
+	}
+	e, err := parseClock(end)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACTIVE_HOURS_END: %w", err)
+	}
+	if s == e {
+		return nil, errors.New("ACTIVE_HOURS_START and ACTIVE_HOURS_END must differ")
+	}
+	return &ActiveHours{Start: s, End: e}, nil
+}
+
+// parseClock parses "H", "HH" or "HH:MM" into minutes after midnight.
+// "24" is accepted as the end of the day.
+func parseClock(v string) (int, error) {
+	hourPart, minutePart, hasMinutes := strings.Cut(v, ":")
+	hour, err := strconv.Atoi(hourPart)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day", v)
+	}
+	minute := 0
+	if hasMinutes {
+		minute, err = strconv.Atoi(minutePart)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a time of day", v)
+		}
+	}
+	total := hour*60 + minute
+	if hour < 0 || minute < 0 || minute > 59 || total > 24*60 {
+		return 0, fmt.Errorf("%q is not a time of day", v)
+	}
+	return total, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActiveHoursContains(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 2, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name       string
+		start, end string
+		t          time.Time
+		want       bool
+	}{
+		{"unset", "", "", at(3, 0), true},
+		{"inside day window", "9", "18", at(12, 0), true},
+		{"start is inclusive", "9", "18", at(9, 0), true},
+		{"end is exclusive", "9", "18", at(18, 0), false},
+		{"before day window", "9", "18", at(8, 59), false},
+		{"minutes", "9:30", "18", at(9, 15), false},
+		{"wrapped, late evening", "22", "06", at(23, 0), true},
+		{"wrapped, early morning", "22", "06", at(5, 59), true},
+		{"wrapped, daytime", "22", "06", at(12, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hours, err := parseActiveHours(tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("parseActiveHours(%q, %q) error = %v", tt.start, tt.end, err)
+			}
+			if got := hours.Contains(tt.t); got != tt.want {
+				t.Errorf("Contains(%s) = %v, want %v", tt.t.Format("15:04"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseActiveHoursErrors(t *testing.T) {
+	for _, tc := range [][2]string{{"9", ""}, {"", "18"}, {"25", "18"}, {"9:60", "18"}, {"nine", "18"}, {"9", "9"}} {
+		_, err := parseActiveHours(tc[0], tc[1])
+		if err == nil {
+			t.Errorf("parseActiveHours(%q, %q) error = nil, want error", tc[0], tc[1])
+		}
+	}
+}
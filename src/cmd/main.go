@@ -1,280 +1,113 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
+	"context"
+	"log/slog"
 	"os"
-	"sort"
-	"strconv"
+	"os/signal"
 	"strings"
-	"time"
+	"syscall"
+	// Embed the timezone database so TIMEZONE loads on scratch and
+	// distroless images that ship without tzdata.
+	_ "time/tzdata"
 
 	"github.com/joho/godotenv"
 )
 
-const (
-	SlackApiBaseUrl = "https://slack.com/api/"
-	ChatGptApiUrl   = "https://api.openai.com/v1/chat/completions"
-	AnswerLimit     = 10
-)
-
-var slackBotToken string
-var chatGptApiKey string
-
-type SlackMessage struct {
-	Type       string `json:"type"`
-	User       string `json:"user"`
-	Text       string `json:"text"`
-	Ts         string `json:"ts"`
-	ThreadTs   string `json:"thread_ts"`
-	ReplyCount int    `json:"reply_count"`
-}
-
-type SlackConversationsHistoryResponse struct {
-	Ok       bool           `json:"ok"`
-	Messages []SlackMessage `json:"messages"`
-	Error    string         `json:"error"`
-	Needed   string         `json:"needed"`
-}
-
-type SlackPostMessageResponse struct {
-	Ok     bool   `json:"ok"`
-	Error  string `json:"error"`
-	Needed string `json:"needed"`
-}
-
-type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-type ChatGPTPayLoad struct {
-	Model     string        `json:"model"`
-	Messages  []ChatMessage `json:"messages"`
-	MaxTokens int           `json:"max_tokens"`
-}
+// DefaultEnvFile is loaded when ENV_FILE is not set.
+const DefaultEnvFile = ".env"
 
-type ChatGptResponse struct {
-	Choices []struct {
-		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
-}
-
-func init() {
-	err := godotenv.Load(".env")
-	if err != nil {
-		fmt.Println("Error loading .env file")
-		return
+// loadEnvFile loads ENV_FILE, or DefaultEnvFile, into the environment.
+// explicit reports whether ENV_FILE named the file. It does not log, as
+// the file may set LOG_LEVEL and LOG_FORMAT for the logger built after
+// it.
+func loadEnvFile() (path string, explicit bool, err error) {
+	path = os.Getenv("ENV_FILE")
+	explicit = path != ""
+	if !explicit {
+		path = DefaultEnvFile
 	}
+	return path, explicit, godotenv.Load(path)
 }
 
 func main() {
-	slackBotToken = os.Getenv("SLACK_BOT_TOKEN")
-	chatGptApiKey = os.Getenv("CHAT_GPT_API_KEY")
-	channelId := os.Getenv("SLACK_CHANNEL_ID")
-
-	messages, err := fetchSlackMessages(channelId)
-	if err != nil {
-		fmt.Println("Error fetching slack message:", err)
-		return
-	}
-
-	sort.Slice(messages, func(i, j int) bool {
-		tsi, err := strconv.ParseFloat(messages[i].Ts, 64)
-		if err != nil {
-			return false
-		}
-
-		tsj, err := strconv.ParseFloat(messages[j].Ts, 64)
-		if err != nil {
-			return false
-		}
-
-		return tsi < tsj
-	})
-
-	var filterMessages []SlackMessage
-	for _, message := range messages {
-		if isQuestion(message.Text) && message.ReplyCount == 0 {
-			filterMessages = append(filterMessages, message)
+	envPath, explicit, err := loadEnvFile()
+	slog.SetDefault(newLogger(os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL")))
+	// A missing default .env is normal when configuration comes from the
+	// process environment, so it is only worth a warning when ENV_FILE
+	// names the file explicitly.
+	if err != nil {
+		if explicit {
+			slog.Warn("Error loading env file", "path", envPath, "error", err)
+		} else {
+			slog.Debug("No env file loaded", "path", envPath, "error", err)
 		}
 	}
+	flags := parseFlags(os.Args[1:])
+	flags.applyToEnv()
 
-	for i, message := range filterMessages {
-		time.Sleep(time.Second * 60)
-		if i > AnswerLimit {
-			break
-		}
-
-		resp, err := sendToChatGpt(message.Text)
-		if err != nil {
-			fmt.Println("Error sending message to ChatGPT:", err)
-			continue
-		}
-
-		respWithMention := fmt.Sprintf("<@%s>\n%s", message.User, resp)
-		err = postToSlackThread(channelId, message.ThreadTs, respWithMention)
-		if err != nil {
-			fmt.Println("Error posting to Slack thread:", err)
-			continue
-		}
-
-		fmt.Println("Post Slack Thread Done")
+	load := LoadConfig
+	if flags.healthcheck {
+		load = LoadHealthcheckConfig
 	}
-}
-
-func fetchSlackMessages(channelId string) ([]SlackMessage, error) {
-	now := time.Now()
-	jst, err := time.LoadLocation("Asia/Tokyo")
+	cfg, err := load()
 	if err != nil {
-		return nil, err
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
 	}
-	yesterday := now.AddDate(0, 0, -1)
-	startTime := time.Date(yesterday.Year(), yesterday.Month(), yesterday.Day(), 20, 0, 0, 0, jst)
-	url := fmt.Sprintf("%sconversations.history?channel=%s&oldest=%d", SlackApiBaseUrl, channelId, startTime.Unix())
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		startMetricsServer(addr)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", slackBotToken))
-
-	client := &http.Client{Timeout: time.Second * 10}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.MaxRuntime > 0 {
+		deadline, cancel := context.WithTimeout(ctx, cfg.MaxRuntime)
+		defer cancel()
+		cfg.Deadline = deadline
 	}
 
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	// Events and Socket Mode answer questions as Slack pushes them;
+	// polling reads channel history on each pass.
+	switch {
+	case flags.healthcheck:
+		err = Healthcheck(ctx, cfg)
+	case flags.backfill:
+		err = Backfill(ctx, cfg, flags.channel, flags.threadTs)
+	case cfg.Mode == ModeSocket:
+		err = ServeSocket(ctx, cfg)
+	case cfg.Mode == ModeEvents:
+		err = Serve(ctx, cfg)
+	default:
+		err = Run(ctx, cfg)
 	}
-
-	var apiResponse SlackConversationsHistoryResponse
-	err = json.Unmarshal(body, &apiResponse)
-	if err != nil {
-		return nil, err
-	}
-
-	if !apiResponse.Ok {
-		return nil, fmt.Errorf("slack API error: %s, needed: %s", apiResponse.Error, apiResponse.Needed)
+	// A healthcheck spends no tokens worth reporting.
+	if chat, ok := cfg.Chat.(*HttpChatClient); ok && !flags.healthcheck {
+		logCost(chat.Usage())
 	}
-
-	return apiResponse.Messages, nil
-}
-
-func isQuestion(s string) bool {
-	return strings.Contains(s, "質問です")
-}
-
-func postToSlackThread(channelId, threadTs, message string) error {
-	url := fmt.Sprintf("%schat.postMessage", SlackApiBaseUrl)
-
-	requestData := map[string]interface{}{
-		"token":     slackBotToken,
-		"channel":   channelId,
-		"text":      message,
-		"thread_ts": threadTs,
-	}
-
-	jsonData, err := json.Marshal(requestData)
 	if err != nil {
-		return err
+		slog.Error("Run failed", "error", err)
+		stop()
+		os.Exit(1)
 	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", slackBotToken))
-
-	client := &http.Client{Timeout: time.Second * 10}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	var apiResponse SlackPostMessageResponse
-	err = json.Unmarshal(body, &apiResponse)
-	if err != nil {
-		return err
-	}
-
-	if !apiResponse.Ok {
-		return fmt.Errorf("slack API error: %s, needed: %s", apiResponse.Error, apiResponse.Needed)
-	}
-
-	return nil
 }
 
-func sendToChatGpt(prompt string) (string, error) {
-	message := []ChatMessage{
-		{
-			Role:    "user",
-			Content: prompt,
-		},
-	}
-
-	requestData := ChatGPTPayLoad{
-		Model:    "gpt-3.5-turbo",
-		Messages: message,
-	}
-
-	jsonData, err := json.Marshal(requestData)
-	if err != nil {
-		return "", err
-	}
-
-	req, err := http.NewRequest("POST", ChatGptApiUrl, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", chatGptApiKey))
-
-	client := &http.Client{Timeout: time.Minute * 15}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	var apiResponse ChatGptResponse
-
-	err = json.Unmarshal(body, &apiResponse)
-	if err != nil {
-		return "", err
+// newLogger builds the process logger: JSON when format is "json", text
+// otherwise, filtered at level ("debug", "info", "warn" or "error";
+// default info).
+func newLogger(format, level string) *slog.Logger {
+	var lvl slog.Level
+	if level != "" {
+		err := lvl.UnmarshalText([]byte(level))
+		if err != nil {
+			lvl = slog.LevelInfo
+		}
 	}
 
-	if len(apiResponse.Choices) == 0 {
-		return "APIからのレスポンスがありませんでした。APIのレート制限にひっかかった可能性がありんす。", nil
+	opts := &slog.HandlerOptions{Level: lvl}
+	if strings.EqualFold(format, "json") {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
 	}
-
-	return apiResponse.Choices[0].Message.Content, nil
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
 }
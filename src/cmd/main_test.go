@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIsQuestion(t *testing.T) {
+	tests := []struct {
+		name     string
+		keywords []string
+		regex    string
+		text     string
+		want     bool
+	}{
+		{
+			name:     "default keyword",
+			keywords: []string{DefaultQuestionKeywords},
+			text:     "質問です。Goのエラー処理について教えてください",
+			want:     true,
+		},
+		{
+			name:     "default keyword in the middle",
+			keywords: []string{DefaultQuestionKeywords},
+			text:     "すみません、質問です",
+			want:     true,
+		},
+		{
+			name:     "no keyword",
+			keywords: []string{DefaultQuestionKeywords},
+			text:     "おはようございます",
+			want:     false,
+		},
+		{
+			name:     "empty text",
+			keywords: []string{DefaultQuestionKeywords},
+			text:     "",
+			want:     false,
+		},
+		{
+			name:     "second of multiple keywords",
+			keywords: []string{"質問です", "question:"},
+			text:     "question: how do I rotate tokens?",
+			want:     true,
+		},
+		{
+			name:     "none of multiple keywords",
+			keywords: []string{"質問です", "question:"},
+			text:     "deploy finished",
+			want:     false,
+		},
+		{
+			name:     "no keywords configured",
+			keywords: nil,
+			text:     "質問です",
+			want:     false,
+		},
+		{
+			name:     "regex takes precedence over keywords",
+			keywords: []string{"質問です"},
+			regex:    `^Q:`,
+			text:     "質問です",
+			want:     false,
+		},
+		{
+			name:  "regex match",
+			regex: `^Q:`,
+			text:  "Q: what is a goroutine?",
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{QuestionKeywords: tt.keywords}
+			if tt.regex != "" {
+				cfg.QuestionRegex = regexp.MustCompile(tt.regex)
+			}
+
+			got := cfg.isQuestion(tt.text)
+			if got != tt.want {
+				t.Errorf("isQuestion(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsQuestionIgnoreCodeBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		ignore bool
+		want   bool
+	}{
+		{
+			name:   "keyword only in code",
+			text:   "```\n// 質問です: why does this panic?\nfmt.Println(x)\n```",
+			ignore: true,
+			want:   false,
+		},
+		{
+			name:   "keyword only in code, not ignoring",
+			text:   "```\n// 質問です: why does this panic?\nfmt.Println(x)\n```",
+			ignore: false,
+			want:   true,
+		},
+		{
+			name:   "keyword in prose before code",
+			text:   "質問です。これはなぜpanicしますか？\n```\nfmt.Println(x)\n```",
+			ignore: true,
+			want:   true,
+		},
+		{
+			name:   "keyword in prose between code blocks",
+			text:   "```a := 1```\n質問です\n```b := 2```",
+			ignore: true,
+			want:   true,
+		},
+		{
+			name:   "keyword in second of two code blocks",
+			text:   "See:\n```a := 1```\nand\n```// 質問です```",
+			ignore: true,
+			want:   false,
+		},
+		{
+			name:   "unclosed fence is prose",
+			text:   "```質問です",
+			ignore: true,
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{QuestionKeywords: []string{DefaultQuestionKeywords}, IgnoreCodeBlocks: tt.ignore}
+			if got := cfg.isQuestion(tt.text); got != tt.want {
+				t.Errorf("isQuestion(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+
+	cfg := Config{QuestionRegex: regexp.MustCompile(`^Q:`), IgnoreCodeBlocks: true}
+	if !cfg.isQuestion("```x```Q: why?") {
+		t.Error("regex did not match the prose left after the code block")
+	}
+}
+
+func TestUserAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		ignored []string
+		user    string
+		want    bool
+	}{
+		{name: "no lists", user: "U1", want: true},
+		{name: "allowlisted", allowed: []string{"U1", "U2"}, user: "U1", want: true},
+		{name: "not allowlisted", allowed: []string{"U2"}, user: "U1", want: false},
+		{name: "denylisted", ignored: []string{"U1"}, user: "U1", want: false},
+		{name: "not denylisted", ignored: []string{"U2"}, user: "U1", want: true},
+		{name: "denylist wins over allowlist", allowed: []string{"U1"}, ignored: []string{"U1"}, user: "U1", want: false},
+		{name: "allowlisted and another user denylisted", allowed: []string{"U1"}, ignored: []string{"U2"}, user: "U1", want: true},
+		{name: "neither list matches", allowed: []string{"U2"}, ignored: []string{"U3"}, user: "U1", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{AllowedUsers: tt.allowed, IgnoredUsers: tt.ignored}
+			if got := cfg.userAllowed(tt.user); got != tt.want {
+				t.Errorf("userAllowed(%q) = %v, want %v", tt.user, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortMessagesByTs(t *testing.T) {
+	tests := []struct {
+		name string
+		ts   []string
+		want []string
+	}{
+		{
+			name: "valid timestamps",
+			ts:   []string{"1704150002.000100", "1704150000.000100", "1704150001.000100"},
+			want: []string{"1704150000.000100", "1704150001.000100", "1704150002.000100"},
+		},
+		{
+			name: "unparseable sorts last in original order",
+			ts:   []string{"bad", "1704150001.000100", "", "1704150000.000100"},
+			want: []string{"1704150000.000100", "1704150001.000100", "bad", ""},
+		},
+		{
+			name: "already sorted",
+			ts:   []string{"1704150000.000100", "1704150000.000200", "1704150001.000100"},
+			want: []string{"1704150000.000100", "1704150000.000200", "1704150001.000100"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msgs []SlackMessage
+			for _, ts := range tt.ts {
+				msgs = append(msgs, SlackMessage{Ts: ts})
+			}
+
+			sortMessagesByTs(msgs)
+
+			var got []string
+			for _, m := range msgs {
+				got = append(got, m.Ts)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("sortMessagesByTs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursor(t *testing.T) {
+	r := &runner{state: &State{Answered: map[string]bool{}, Cursors: map[string]string{}}}
+	if _, ok := r.cursor("C1"); ok {
+		t.Fatal("cursor() found a cursor before any run")
+	}
+
+	latest := time.Unix(1704150100, 0)
+	r.advanceCursor("C1", latest, earliestTs("1704150050.000200", "1704150020.000100"))
+	got, ok := r.cursor("C1")
+	if !ok || !got.Equal(time.Unix(1704150020, 0)) {
+		t.Errorf("cursor() after a pending question = %v, %v, want %v", got, ok, time.Unix(1704150020, 0))
+	}
+
+	r.advanceCursor("C1", latest, "")
+	got, ok = r.cursor("C1")
+	if !ok || !got.Equal(latest) {
+		t.Errorf("cursor() after a complete run = %v, %v, want %v", got, ok, latest)
+	}
+
+	r.cfg.DryRun = true
+	r.advanceCursor("C1", latest.Add(time.Hour), "")
+	if got, _ := r.cursor("C1"); !got.Equal(latest) {
+		t.Errorf("cursor() after a dry run = %v, want it unchanged at %v", got, latest)
+	}
+}
+
+func TestIsHumanMessageEditsAndDeletions(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    bool
+	}{
+		{
+			name:    "edited question",
+			payload: `{"type":"message","user":"U1","text":"質問です (edited)","ts":"1704150000.000100","edited":{"user":"U1","ts":"1704150060.000000"}}`,
+			want:    true,
+		},
+		{
+			name:    "message_changed notice",
+			payload: `{"type":"message","subtype":"message_changed","hidden":true,"ts":"1704150060.000200","message":{"type":"message","user":"U1","text":"質問です","ts":"1704150000.000100"},"previous_message":{"type":"message","user":"U1","text":"hi","ts":"1704150000.000100"}}`,
+			want:    false,
+		},
+		{
+			name:    "message_deleted notice",
+			payload: `{"type":"message","subtype":"message_deleted","hidden":true,"deleted_ts":"1704150000.000100","ts":"1704150120.000300","previous_message":{"type":"message","user":"U1","text":"質問です","ts":"1704150000.000100"}}`,
+			want:    false,
+		},
+		{
+			name:    "tombstone of a deleted thread parent",
+			payload: `{"type":"message","subtype":"tombstone","user":"USLACKBOT","text":"This message was deleted.","ts":"1704150000.000100","reply_count":2}`,
+			want:    false,
+		},
+	}
+
+	r := &runner{botUserId: "UBOT"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m SlackMessage
+			if err := json.Unmarshal([]byte(tt.payload), &m); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if got := r.isHumanMessage(m); got != tt.want {
+				t.Errorf("isHumanMessage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are labelled by channel ID; the bot only watches a handful of
+// channels, so the cardinality stays small.
+var (
+	questionsDetected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "slack_reply_questions_detected_total",
+		Help: "Unanswered questions found in channel history.",
+	}, []string{"channel"})
+
+	answersPosted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "slack_reply_answers_posted_total",
+		Help: "Answers successfully posted to Slack.",
+	}, []string{"channel"})
+
+	chatGptErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "slack_reply_chatgpt_errors_total",
+		Help: "ChatGPT requests that failed.",
+	}, []string{"channel"})
+
+	slackErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "slack_reply_slack_errors_total",
+		Help: "Slack API calls that failed, by operation.",
+	}, []string{"channel", "operation"})
+
+	chatGptLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "slack_reply_chatgpt_request_duration_seconds",
+		Help:    "Time taken by ChatGPT to answer, including retries.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+	})
+)
+
+// startMetricsServer serves /metrics on addr in the background. The server
+// lives only as long as the process, so a one-shot run exposes metrics
+// only while it is still working.
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		slog.Info("Serving metrics", "addr", addr)
+		err := http.ListenAndServe(addr, mux)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server stopped", "addr", addr, "error", err)
+		}
+	}()
+}
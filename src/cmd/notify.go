@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// NotifyTimeout bounds each NOTIFY_WEBHOOK_URL request.
+const NotifyTimeout = 5 * time.Second
+
+// AnswerNotification is posted to NOTIFY_WEBHOOK_URL after each answer.
+type AnswerNotification struct {
+	Channel   string `json:"channel"`
+	Ts        string `json:"ts"`
+	User      string `json:"user"`
+	Question  string `json:"question"`
+	Answer    string `json:"answer"`
+	Model     string `json:"model"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+// Notifier posts AnswerNotifications to a webhook in the background, so
+// a slow or failing receiver never holds up or fails an answer.
+type Notifier struct {
+	HttpClient *http.Client
+	Url        string
+
+	wg sync.WaitGroup
+}
+
+// Notify sends notification without waiting for the result. Failures are
+// logged. A nil Notifier does nothing.
+func (n *Notifier) Notify(notification AnswerNotification) {
+	if n == nil {
+		return
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		err := n.send(notification)
+		if err != nil {
+			slog.Warn("Error sending answer notification", "channel", notification.Channel, "ts", notification.Ts, "error", err)
+		}
+	}()
+}
+
+// Wait blocks until notifications in flight are sent or have timed out,
+// so a single run does not exit before delivering them.
+func (n *Notifier) Wait() {
+	if n == nil {
+		return
+	}
+	n.wg.Wait()
+}
+
+func (n *Notifier) send(notification AnswerNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), NotifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", n.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
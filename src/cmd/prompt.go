@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+)
+
+// languageHint returns a system instruction asking ChatGPT to answer in
+// the question's language. Kana only appear in Japanese, so their
+// presence pins the language explicitly; otherwise the model is asked to
+// mirror whatever language the question uses.
+func languageHint(text string) string {
+	for _, r := range text {
+		if unicode.In(r, unicode.Hiragana, unicode.Katakana) {
+			return "The question is written in Japanese. Answer in Japanese."
+		}
+	}
+	return "Answer in the same language as the question."
+}
+
+// PromptData is what a PROMPT_TEMPLATE can refer to.
+type PromptData struct {
+	Text    string
+	User    string
+	Channel string
+}
+
+// parsePromptTemplate parses a PROMPT_TEMPLATE and renders it once with
+// empty data, so references to unknown fields fail at startup rather than
+// on the first question. An empty template yields nil.
+func parsePromptTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("prompt").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	err = tmpl.Execute(io.Discard, PromptData{})
+	if err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// loadFewShot reads the FEWSHOT_FILE at path: a JSON array of
+// {"role", "content"} messages showing ChatGPT the expected style. Roles
+// must be system, user or assistant. An empty path yields no examples.
+func loadFewShot(path string) ([]ChatMessage, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var examples []ChatMessage
+	err = decoder.Decode(&examples)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	for i, m := range examples {
+		switch m.Role {
+		case "system", "user", "assistant":
+		default:
+			return nil, fmt.Errorf("parsing %s: message %d has invalid role %q, want system, user or assistant", path, i, m.Role)
+		}
+		if strings.TrimSpace(m.Content) == "" {
+			return nil, fmt.Errorf("parsing %s: message %d has no content", path, i)
+		}
+	}
+	return examples, nil
+}
+
+// renderPrompt frames the question with cfg.PromptTemplate, or returns
+// the text as is when no template is set or rendering fails.
+func (cfg Config) renderPrompt(data PromptData) string {
+	if cfg.PromptTemplate == nil {
+		return data.Text
+	}
+	var b strings.Builder
+	err := cfg.PromptTemplate.Execute(&b, data)
+	if err != nil {
+		slog.Warn("Error rendering PROMPT_TEMPLATE, sending the question as is", "channel", data.Channel, "error", err)
+		return data.Text
+	}
+	return b.String()
+}
+
+// fileNotes describes the message's attachments for ChatGPT. Images not
+// in attached, the IDs of files sent along as images, are marked as
+// unseen. It returns "" for messages without files.
+func fileNotes(files []SlackFile, attached map[string]bool) string {
+	var b strings.Builder
+	for _, file := range files {
+		name := file.Name
+		if name == "" {
+			name = "an unnamed file"
+		}
+		fmt.Fprintf(&b, "\n(the user attached a file named %s", name)
+		if strings.HasPrefix(file.Mimetype, "image/") && !attached[file.Id] {
+			b.WriteString("; it is an image, which you cannot see")
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+// DefaultContextWindow is assumed for models missing from
+// ModelContextWindows, and DefaultCompletionReserve is kept free for the
+// answer when MAX_TOKENS is not set.
+const (
+	DefaultContextWindow     = 4096
+	DefaultCompletionReserve = 1024
+)
+
+// ModelContextWindows is the context size, in tokens, of the models the
+// bot is commonly run with. Dated snapshots such as "gpt-4o-2024-05-13"
+// match by prefix.
+var ModelContextWindows = map[string]int{
+	"gpt-3.5-turbo": 16385,
+	"gpt-4":         8192,
+	"gpt-4-32k":     32768,
+	"gpt-4-turbo":   128000,
+	"gpt-4o":        128000,
+	"gpt-4o-mini":   128000,
+	"gpt-4.1":       1047576,
+	"gpt-4.5":       128000,
+	"o1":            200000,
+	"o1-mini":       128000,
+	"o3":            200000,
+	"o4-mini":       200000,
+}
+
+// contextWindow returns the context size for model, using the longest
+// ModelContextWindows key that prefixes it.
+func contextWindow(model string) int {
+	window, matched := DefaultContextWindow, 0
+	for name, size := range ModelContextWindows {
+		if strings.HasPrefix(model, name) && len(name) > matched {
+			window, matched = size, len(name)
+		}
+	}
+	return window
+}
+
+// estimateTokens roughly counts the tokens in s without a tokenizer: four
+// ASCII characters per token, and one token per other rune, since
+// Japanese text tokenizes at about a character per token.
+func estimateTokens(s string) int {
+	ascii, other := 0, 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// truncateToTokens cuts s so that estimateTokens(s) is at most n.
+func truncateToTokens(s string, n int) string {
+	ascii, other := 0, 0
+	for i, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+		if (ascii+3)/4+other > n {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// fitContext keeps messages within the model's context window minus the
+// tokens reserved for the answer. The first keep messages, the system
+// prompt and few-shot examples, are never dropped: it drops the oldest
+// non-system thread history after them first and then truncates the
+// final message, the question itself. It fails when even an empty
+// question would not fit.
+func fitContext(messages []ChatMessage, keep int, model string, maxTokens int) ([]ChatMessage, error) {
+	reserve := maxTokens
+	if reserve <= 0 {
+		reserve = DefaultCompletionReserve
+	}
+	budget := contextWindow(model) - reserve
+
+	total := 0
+	for _, m := range messages {
+		total += estimateTokens(m.Content)
+	}
+	if total <= budget {
+		return messages, nil
+	}
+	estimated := total
+
+	dropped := 0
+	for i := keep; total > budget && i < len(messages)-1; {
+		if messages[i].Role == "system" {
+			i++
+			continue
+		}
+		total -= estimateTokens(messages[i].Content)
+		messages = append(messages[:i], messages[i+1:]...)
+		dropped++
+	}
+
+	truncated := false
+	if total > budget {
+		last := &messages[len(messages)-1]
+		allowed := budget - (total - estimateTokens(last.Content))
+		if allowed <= 0 {
+			return nil, fmt.Errorf("prompt of about %d tokens does not fit the %d-token budget for %s", estimated, budget, model)
+		}
+		last.Content = truncateToTokens(last.Content, allowed)
+		truncated = true
+	}
+
+	slog.Warn("Prompt exceeds model context, trimming", "model", model, "estimated_tokens", estimated, "budget", budget, "dropped_history", dropped, "truncated_question", truncated)
+	return messages, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLanguageHint(t *testing.T) {
+	const japanese = "The question is written in Japanese. Answer in Japanese."
+	const mirror = "Answer in the same language as the question."
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "japanese with kana", text: "質問です。Goのエラー処理について教えてください", want: japanese},
+		{name: "katakana only", text: "デプロイ", want: japanese},
+		{name: "english", text: "How do I rotate the API token?", want: mirror},
+		// Kanji alone are shared with Chinese, so without kana the model
+		// is left to mirror the question rather than told Japanese.
+		{name: "kanji only", text: "質問", want: mirror},
+		{name: "empty", text: "", want: mirror},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := languageHint(tt.text); got != tt.want {
+				t.Errorf("languageHint(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunAddsLanguageHint(t *testing.T) {
+	slack := newFakeSlack()
+	slack.messages["C1"] = []SlackMessage{
+		{Type: "message", User: "U1", Text: "質問です。ログの見方を教えてください", Ts: recentTs(2 * time.Minute)},
+		{Type: "message", User: "U2", Text: "question: how do I read the logs?", Ts: recentTs(time.Minute)},
+	}
+	chat := &fakeChat{}
+	cfg := newTestConfig(slack, chat)
+	cfg.QuestionKeywords = []string{DefaultQuestionKeywords, "question:"}
+	cfg.MatchLanguage = true
+
+	err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(chat.histories) != 2 {
+		t.Fatalf("ChatGPT calls = %d, want 2", len(chat.histories))
+	}
+	wants := []string{
+		"The question is written in Japanese. Answer in Japanese.",
+		"Answer in the same language as the question.",
+	}
+	for i, history := range chat.histories {
+		want := wants[i]
+		if len(history) == 0 || history[0].Role != "system" || history[0].Content != want {
+			t.Errorf("history for %q = %+v, want it to start with the system hint %q", chat.prompts[i], history, want)
+		}
+	}
+}
+
+func TestContextWindow(t *testing.T) {
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{model: "gpt-4", want: 8192},
+		{model: "gpt-4o-2024-05-13", want: 128000},
+		{model: "gpt-4.1", want: 1047576},
+		{model: "gpt-4.1-mini", want: 1047576},
+		{model: "o1-mini", want: 128000},
+		{model: "unknown-model", want: DefaultContextWindow},
+	}
+
+	for _, tt := range tests {
+		if got := contextWindow(tt.model); got != tt.want {
+			t.Errorf("contextWindow(%q) = %d, want %d", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestFitContextKeepsFewShot(t *testing.T) {
+	long := strings.Repeat("あ", 4000)
+	messages := []ChatMessage{
+		{Role: "system", Content: "You are helpful."},
+		{Role: "user", Content: "example question"},
+		{Role: "assistant", Content: "example answer"},
+		{Role: "user", Content: "old " + long},
+		{Role: "assistant", Content: "recent " + long},
+		{Role: "user", Content: "質問です"},
+	}
+
+	// gpt-4 leaves 8192-1024 tokens: the oldest history message must go,
+	// but not the few-shot pair before it.
+	got, err := fitContext(messages, 3, "gpt-4", 0)
+	if err != nil {
+		t.Fatalf("fitContext() error = %v", err)
+	}
+	var contents []string
+	for _, m := range got {
+		contents = append(contents, strings.TrimSuffix(m.Content, long))
+	}
+	want := "You are helpful.|example question|example answer|recent |質問です"
+	if strings.Join(contents, "|") != want {
+		t.Errorf("fitContext() kept %q, want %q", contents, want)
+	}
+}
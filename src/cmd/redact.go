@@ -0,0 +1,72 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Placeholders that replace redacted text. They stay fixed so the model
+// can still tell what kind of value was there.
+const (
+	RedactedEmail  = "[REDACTED_EMAIL]"
+	RedactedSecret = "[REDACTED_SECRET]"
+	RedactedCard   = "[REDACTED_CARD]"
+)
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// secretPatterns match well-known token prefixes (OpenAI, Slack,
+	// GitHub, AWS) and long unbroken hex runs.
+	secretPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\b(?:sk-|xox[abprs]-|xapp-|gh[pousr]_|AKIA)[A-Za-z0-9_-]{12,}`),
+		regexp.MustCompile(`\b[0-9a-fA-F]{32,}\b`),
+	}
+	// base64Pattern matches long base64-alphabet runs. Paths and long
+	// identifiers match it too, so looksLikeBase64Secret decides.
+	base64Pattern = regexp.MustCompile(`[A-Za-z0-9+/_-]{40,}={0,2}`)
+	// cardPattern matches 13 to 19 digits, optionally grouped with single
+	// spaces or dashes.
+	cardPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+)
+
+// redact masks email addresses, API-key-looking strings and
+// credit-card-shaped digit runs in text.
+func redact(text string) string {
+	text = emailPattern.ReplaceAllString(text, RedactedEmail)
+	for _, pattern := range secretPatterns {
+		text = pattern.ReplaceAllString(text, RedactedSecret)
+	}
+	text = base64Pattern.ReplaceAllStringFunc(text, func(s string) string {
+		if looksLikeBase64Secret(s) {
+			return RedactedSecret
+		}
+		return s
+	})
+	return cardPattern.ReplaceAllString(text, RedactedCard)
+}
+
+// looksLikeBase64Secret reports whether s, a base64Pattern match, is
+// likely encoded data rather than a path or identifier. Padding is taken
+// as proof. Otherwise s must mix upper case, lower case and digits, as
+// random data does, and have no slash, as paths and URLs do.
+func looksLikeBase64Secret(s string) bool {
+	if strings.HasSuffix(s, "=") {
+		return true
+	}
+	if strings.Contains(s, "/") {
+		return false
+	}
+	var upper, lower, digit bool
+	for _, r := range s {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	return upper && lower && digit
+}
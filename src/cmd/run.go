@@ -0,0 +1,1075 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// AnswerLimit is the default number of questions answered per channel
+	// and run, overridable with ANSWER_LIMIT.
+	AnswerLimit = 10
+	// DefaultReplyIntervalSeconds is the pause between ChatGPT calls,
+	// overridable with REPLY_INTERVAL_SECONDS.
+	DefaultReplyIntervalSeconds = 60
+	// DefaultSleepJitterPercent spreads the reply interval by up to this
+	// much either way when SLEEP_JITTER_PERCENT is not set.
+	DefaultSleepJitterPercent = 20
+	// DefaultQuestionKeywords is used when QUESTION_KEYWORDS is unset. An
+	// empty QUESTION_KEYWORDS matches nothing.
+	DefaultQuestionKeywords = "質問です"
+	// DefaultThreadHistoryLimit is how many earlier thread messages are sent
+	// to ChatGPT as context when THREAD_HISTORY_LIMIT is not set.
+	DefaultThreadHistoryLimit = 10
+	// DefaultProcessingReaction and DefaultDoneReaction are the emoji used
+	// when PROCESSING_REACTION and DONE_REACTION are unset.
+	DefaultProcessingReaction = "eyes"
+	DefaultDoneReaction       = "white_check_mark"
+	// DryRunStubAnswer stands in for ChatGPT's answer when
+	// DRY_RUN_STUB_CHATGPT is set.
+	DryRunStubAnswer = "(dry run: ChatGPT was not called)"
+
+	// PlaceholderMessage is posted while ChatGPT works on an answer with
+	// USE_PLACEHOLDER.
+	PlaceholderMessage = "🤖 thinking…"
+	// DefaultErrorMessage tells the asker ChatGPT failed, when
+	// ON_ERROR_MESSAGE is not set.
+	DefaultErrorMessage = "Sorry, ChatGPT could not answer right now. Please try again later."
+)
+
+// What to do when ChatGPT fails, selected by ON_ERROR.
+const (
+	// OnErrorSkip logs the failure and leaves the question for a later
+	// pass.
+	OnErrorSkip = "skip"
+	// OnErrorNotify also posts ErrorMessage to the thread.
+	OnErrorNotify = "notify"
+)
+
+// Config holds everything Run needs, including the clients it talks to,
+// so tests can substitute fakes for Slack and ChatGPT.
+type Config struct {
+	ChannelIds       []string
+	QuestionKeywords []string
+	QuestionRegex    *regexp.Regexp
+	// IgnoreCodeBlocks leaves ``` code blocks out of question detection,
+	// so a keyword in pasted code does not make the message a question.
+	IgnoreCodeBlocks bool
+	// PromptTemplate, when set, frames each question before it is sent
+	// to ChatGPT. See PromptData for the fields it can use.
+	PromptTemplate *template.Template
+	// Channels holds per-channel overrides loaded from CHANNEL_CONFIG.
+	// Channels not listed use the global settings.
+	Channels map[string]ChannelConfig
+	// SummaryChannelId, when set, also receives each pass's RunSummary
+	// as a message.
+	SummaryChannelId string
+	// AllowedUsers, when non-empty, limits answers to questions from
+	// these user IDs. IgnoredUsers are never answered, even if allowed.
+	AllowedUsers  []string
+	IgnoredUsers  []string
+	AnswerLimit   int
+	ReplyInterval time.Duration
+	// SleepJitterPercent randomizes each ReplyInterval sleep by up to
+	// this percentage either way, so instances started on the same
+	// schedule drift apart instead of hitting the APIs in lockstep.
+	SleepJitterPercent int
+	// Concurrency is how many questions are answered at once. 1 keeps
+	// answers strictly in order.
+	Concurrency        int
+	ThreadHistoryLimit int
+	StateFile          string
+	// PollInterval, when positive, keeps Run polling at that interval
+	// instead of returning after one pass.
+	PollInterval time.Duration
+	// ActiveHours, when set, limits answering to that daily window in
+	// Location. Questions outside it are left for a later pass.
+	ActiveHours *ActiveHours
+	// MaxReplyChars, when positive, caps the length of the answer posted
+	// to Slack across all chunks.
+	MaxReplyChars int
+	// AnswerIfHumanReplied answers threads that people have already
+	// replied to, skipping only those the bot has replied to itself. It is
+	// on unless ANSWER_IF_HUMAN_REPLIED=false.
+	AnswerIfHumanReplied bool
+	// MaxAge, when positive, skips questions posted longer ago than this,
+	// even when they fall inside the history window.
+	MaxAge time.Duration
+
+	// Lookback, when positive, makes the history window start that long
+	// before now. When zero the window starts at 20:00 yesterday in
+	// Location.
+	Lookback time.Duration
+	Location *time.Location
+	// LatestOverride, when non-zero, replaces now as the end of the
+	// history window. It exists for testing against past messages.
+	LatestOverride time.Time
+
+	// DryRun prints replies instead of posting them. DryRunStubChatGpt
+	// additionally replaces the ChatGPT call with DryRunStubAnswer.
+	DryRun            bool
+	DryRunStubChatGpt bool
+	// DryRunOutput receives the replies a dry run would post. Nil means
+	// os.Stdout.
+	DryRunOutput io.Writer
+	// Preview is set when answers are ephemeral, seen only by a reviewer.
+	// Previewed questions are answered once per process but not saved as
+	// answered, so they get a public answer once preview is turned off.
+	Preview bool
+
+	// GreetByName starts each reply with "Hi {name}," using the asker's
+	// Slack display name.
+	GreetByName bool
+	// MatchLanguage adds a system instruction to answer in the language
+	// the question was asked in.
+	MatchLanguage bool
+
+	// ProcessingReaction is added to a question when work on it starts and
+	// DoneReaction once the reply is posted. Empty names add nothing.
+	ProcessingReaction string
+	DoneReaction       string
+
+	// MaxRuntime bounds a whole run in poll mode. main turns it into
+	// Deadline, after which no new answer is started; answers in flight
+	// finish.
+	MaxRuntime time.Duration
+	Deadline   context.Context
+
+	// Notifier, when set, is told about every posted answer.
+	Notifier *Notifier
+
+	// JsonMode asks ChatGPT for a JSON object and posts it as a code
+	// block.
+	JsonMode bool
+
+	// ReplyFooter, when set, ends every answer, e.g. a disclaimer.
+	ReplyFooter string
+
+	// EnableVision sends image attachments to vision-capable models
+	// instead of only mentioning them.
+	EnableVision bool
+	// OnError is OnErrorSkip or OnErrorNotify. ErrorMessage is what the
+	// asker is told when ChatGPT fails.
+	OnError      string
+	ErrorMessage string
+	// OneReplyPerThread answers only the earliest question in each
+	// thread.
+	OneReplyPerThread bool
+	// UsePlaceholder posts PlaceholderMessage before asking ChatGPT and
+	// edits it into the answer, so slow models show progress. If ChatGPT
+	// fails the placeholder becomes the OnErrorNotify notice, or is
+	// deleted so the retry on the next pass starts afresh.
+	UsePlaceholder bool
+	// ReplyInDm answers channel questions in a DM with the asker instead
+	// of the question's thread. Questions asked in a DM are always
+	// answered there.
+	ReplyInDm bool
+
+	// UseBlockKit posts replies as Block Kit sections with a context line
+	// naming Model, instead of plain text.
+	UseBlockKit bool
+	Model       string
+
+	// Mode is one of ModePoll, ModeEvents or ModeSocket. ServerAddr is
+	// where the events server listens in ModeEvents.
+	Mode       string
+	ServerAddr string
+	// SigningSecret verifies that requests to the events server come
+	// from Slack.
+	SigningSecret string
+	// DebounceDelay is how long an event-driven question must go
+	// unedited before it is answered, so a burst of edits yields one
+	// answer to the final text. Zero answers at once.
+	DebounceDelay time.Duration
+
+	Slack SlackClient
+	Chat  ChatClient
+}
+
+type runner struct {
+	cfg       Config
+	botUserId string
+	// stop is done once no new answer should start: on shutdown or at
+	// cfg.Deadline.
+	stop context.Context
+
+	// mu guards state and userNames, which concurrent answers share.
+	mu    sync.Mutex
+	state *State
+	// userNames caches display names by user ID for the run.
+	userNames map[string]string
+	summary   RunSummary
+	// answerCache maps answerCacheKey of a question to ChatGPT's answer,
+	// so reposts of the same question cost nothing.
+	answerCache map[string]string
+	// debounce delays queueing event-driven messages by DebounceDelay.
+	debounce *debouncer
+}
+
+// newRunner loads the answered-set and looks up the bot's own user ID.
+func newRunner(ctx context.Context, cfg Config) (*runner, error) {
+	state, err := loadState(cfg.StateFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading state file: %w", err)
+	}
+
+	botUserId, err := cfg.Slack.FetchBotUserId(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching bot user ID: %w", err)
+	}
+
+	return &runner{
+		cfg:         cfg,
+		state:       state,
+		botUserId:   botUserId,
+		userNames:   map[string]string{},
+		answerCache: map[string]string{},
+		debounce:    newDebouncer(cfg.DebounceDelay),
+	}, nil
+}
+
+// Run answers unanswered questions in every configured channel. Errors for
+// individual channels or messages are logged and skipped; only setup
+// failures and ErrAuth, which no later call would get past, are returned.
+// With PollInterval set it repeats until ctx is cancelled, otherwise it
+// makes a single pass. Each pass ends with a RunSummary.
+func Run(ctx context.Context, cfg Config) error {
+	r, err := newRunner(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer cfg.Notifier.Wait()
+
+	// stop gates starting new work; calls already in flight keep ctx, so
+	// passing the deadline never cuts a post in half.
+	stop, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if cfg.Deadline != nil {
+		context.AfterFunc(cfg.Deadline, cancel)
+	}
+	r.stop = stop
+
+	for {
+		oldest, latest, err := cfg.window(time.Now())
+		if err != nil {
+			return fmt.Errorf("computing history window: %w", err)
+		}
+		slog.Info("Starting run", "channels", cfg.ChannelIds, "oldest", oldest, "latest", latest)
+		started := time.Now()
+
+		for _, channelId := range cfg.ChannelIds {
+			if stop.Err() != nil {
+				break
+			}
+			err := r.processChannel(ctx, channelId, oldest, latest)
+			if err != nil {
+				r.reportSummary(ctx, started)
+				return fmt.Errorf("channel %s: %w", channelId, err)
+			}
+		}
+		r.reportSummary(ctx, started)
+
+		if cfg.PollInterval <= 0 {
+			return nil
+		}
+
+		err = sleepContext(stop, cfg.PollInterval)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Info("Max runtime reached, stopping poller", "max_runtime", cfg.MaxRuntime)
+			} else {
+				slog.Info("Stopping poller", "reason", err)
+			}
+			return nil
+		}
+	}
+}
+
+// processChannel answers up to AnswerLimit unanswered questions posted to
+// the channel between oldest and latest. It returns only ErrAuth errors;
+// anything else is logged and the channel is skipped or continued.
+func (r *runner) processChannel(ctx context.Context, channelId string, oldest, latest time.Time) error {
+	// A cursor replaces the window's start, so a run picks up where the
+	// last one stopped. Backfills with LatestOverride keep the window.
+	if cursor, ok := r.cursor(channelId); ok && r.cfg.LatestOverride.IsZero() {
+		oldest = cursor
+	}
+
+	messages, err := r.cfg.Slack.FetchMessages(ctx, channelId, oldest, latest)
+	if err != nil {
+		slog.Error("Error fetching slack messages", "channel", channelId, "class", errorClass(err), "error", err)
+		slackErrors.WithLabelValues(channelId, "fetch").Inc()
+		r.record(func(s *RunSummary) { s.Errors++ })
+		if errors.Is(err, ErrAuth) {
+			return err
+		}
+		return nil
+	}
+
+	sortMessagesByTs(messages)
+
+	now := time.Now()
+	var filterMessages []SlackMessage
+	// unchecked is the first question whose thread could not be read; the
+	// cursor must not move past it.
+	unchecked := ""
+	for _, message := range messages {
+		if !r.shouldAnswer(channelId, message) {
+			continue
+		}
+		if r.cfg.tooOld(message, now) {
+			slog.Debug("Skipping stale question", "channel", channelId, "ts", message.Ts, "max_age", r.cfg.MaxAge)
+			continue
+		}
+		// conversations.replies is heavily rate limited, so the thread is
+		// fetched once for both checks.
+		if message.ReplyCount > 0 {
+			replies, err := r.cfg.Slack.FetchThreadReplies(ctx, channelId, message.Ts)
+			if err != nil {
+				slog.Warn("Error fetching thread replies, skipping question", "channel", channelId, "ts", message.Ts, "error", err)
+				slackErrors.WithLabelValues(channelId, "replies").Inc()
+				if unchecked == "" {
+					unchecked = message.Ts
+				}
+				continue
+			}
+			if alreadyAnsweredByBot(replies, message.Ts, message.User, r.botUserId) {
+				slog.Debug("Skipping question the bot already answered", "channel", channelId, "ts", message.Ts)
+				continue
+			}
+			if !r.threadNeedsAnswer(channelId, message, replies) {
+				continue
+			}
+		}
+		filterMessages = append(filterMessages, message)
+	}
+	if r.cfg.OneReplyPerThread {
+		filterMessages = r.firstPerThread(channelId, filterMessages)
+	}
+
+	questionsDetected.WithLabelValues(channelId).Add(float64(len(filterMessages)))
+	r.record(func(s *RunSummary) {
+		s.MessagesFetched += len(messages)
+		s.QuestionsDetected += len(filterMessages)
+	})
+	if len(filterMessages) == 0 {
+		slog.Info("No unanswered questions found in window", "channel", channelId, "oldest", oldest, "latest", latest, "messages", len(messages))
+		r.advanceCursor(channelId, latest, unchecked)
+		return nil
+	}
+	slog.Info("Found unanswered questions", "channel", channelId, "count", len(filterMessages), "limit", r.cfg.AnswerLimit)
+	if !r.cfg.ActiveHours.Contains(now.In(r.cfg.Location)) {
+		slog.Info("Outside active hours, leaving questions for later", "channel", channelId, "count", len(filterMessages))
+		r.advanceCursor(channelId, latest, earliestTs(unchecked, filterMessages[0].Ts))
+		return nil
+	}
+
+	// Up to Concurrency answers run at once, each started ReplyInterval
+	// after the previous one. A slot is taken before the pause, so with
+	// Concurrency 1 the pause follows the previous answer as it always has.
+	sem := make(chan struct{}, max(r.cfg.Concurrency, 1))
+	// retry is indexed by position, so each answer writes its own slot.
+	// Questions never started stay set.
+	retry := make([]bool, len(filterMessages))
+	for i := range retry {
+		retry[i] = true
+	}
+	var wg sync.WaitGroup
+	var authMu sync.Mutex
+	var authErr error
+	failed := func() bool {
+		authMu.Lock()
+		defer authMu.Unlock()
+		return authErr != nil
+	}
+
+	for i, message := range filterMessages {
+		if i >= r.cfg.AnswerLimit {
+			break
+		}
+		sem <- struct{}{}
+		if failed() {
+			<-sem
+			break
+		}
+		if i > 0 {
+			// An error means r.stop is done, which is handled below.
+			_ = sleepContext(r.stop, jitter(r.cfg.ReplyInterval, r.cfg.SleepJitterPercent))
+		}
+		if r.stop.Err() != nil {
+			if ctx.Err() != nil {
+				slog.Info("Stopping", "channel", channelId, "error", ctx.Err())
+			} else {
+				slog.Warn("Max runtime reached, leaving remaining questions for the next run", "channel", channelId, "remaining", min(len(filterMessages), r.cfg.AnswerLimit)-i)
+			}
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, message SlackMessage) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := r.answer(ctx, channelId, message)
+			retry[i] = err != nil && shouldRetryAnswer(err)
+			if err != nil && !retry[i] {
+				slog.Warn("Giving up on question after a permanent failure", "channel", channelId, "ts", message.Ts, "class", errorClass(err), "error", err)
+			}
+			if errors.Is(err, ErrAuth) {
+				authMu.Lock()
+				if authErr == nil {
+					authErr = err
+				}
+				authMu.Unlock()
+			}
+		}(i, message)
+	}
+	wg.Wait()
+
+	// The cursor stops at the first question left unanswered by
+	// AnswerLimit, the deadline or an outage, so the next run retries it.
+	// Permanent failures would fail again, so the cursor moves past them.
+	pending := ""
+	for i, message := range filterMessages {
+		if retry[i] {
+			pending = message.Ts
+			break
+		}
+	}
+	r.advanceCursor(channelId, latest, earliestTs(unchecked, pending))
+	return authErr
+}
+
+// shouldRetryAnswer reports whether a failed answer may succeed on a
+// later pass: ChatGPT or Slack was unavailable or rate limiting, or the
+// run was stopped mid-answer.
+func shouldRetryAnswer(err error) bool {
+	return isOutage(err) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// answer runs the full pipeline for one question: gather thread context,
+// ask ChatGPT, and post the reply under the question. The error is
+// already logged; callers only use it to decide whether to carry on.
+func (r *runner) answer(ctx context.Context, channelId string, message SlackMessage) error {
+	if !r.cfg.DryRun {
+		r.addReaction(ctx, channelId, message.Ts, r.cfg.ProcessingReaction)
+	}
+
+	// Threads add little in a one-to-one conversation, so DM answers go
+	// straight into the conversation.
+	replyChannel, threadTs := channelId, effectiveThreadTs(message)
+	if isDirectMessageChannel(channelId) || r.cfg.ReplyInDm {
+		threadTs = ""
+	}
+	if r.cfg.ReplyInDm && !isDirectMessageChannel(channelId) && !r.cfg.DryRun {
+		var err error
+		replyChannel, err = r.cfg.Slack.OpenDirectMessage(ctx, message.User)
+		if err != nil {
+			slog.Error("Error opening DM", "channel", channelId, "ts", message.Ts, "user", message.User, "class", errorClass(err), "error", err)
+			slackErrors.WithLabelValues(channelId, "open_dm").Inc()
+			r.record(func(s *RunSummary) { s.Errors++ })
+			return err
+		}
+	}
+
+	placeholderTs := ""
+	if r.cfg.UsePlaceholder && !r.cfg.DryRun {
+		var err error
+		placeholderTs, err = r.cfg.Slack.PostToThread(ctx, replyChannel, threadTs, PlaceholderMessage)
+		if err != nil {
+			slog.Warn("Error posting placeholder, answering without it", "channel", channelId, "ts", message.Ts, "class", errorClass(err), "error", err)
+			slackErrors.WithLabelValues(channelId, "placeholder").Inc()
+		}
+	}
+
+	// A thread parent has no earlier messages to give as context.
+	var history []ChatMessage
+	if message.ThreadTs != "" && message.ThreadTs != message.Ts {
+		replies, err := r.cfg.Slack.FetchThreadReplies(ctx, channelId, message.ThreadTs)
+		if err != nil {
+			slog.Warn("Error fetching thread replies, answering without context", "channel", channelId, "ts", message.Ts, "error", err)
+			slackErrors.WithLabelValues(channelId, "replies").Inc()
+		} else {
+			history = threadHistory(replies, message.Ts, r.cfg.ThreadHistoryLimit)
+		}
+	}
+
+	if r.cfg.MatchLanguage {
+		hint := ChatMessage{Role: "system", Content: languageHint(message.Text)}
+		history = append([]ChatMessage{hint}, history...)
+	}
+
+	opts := r.cfg.chatOptions(channelId)
+	model := opts.Model
+	if model == "" {
+		model = r.cfg.Model
+	}
+	var attached map[string]bool
+	if r.cfg.EnableVision && supportsVision(model) && !r.cfg.DryRunStubChatGpt {
+		opts.Images, attached = r.attachImages(ctx, channelId, message)
+	}
+	prompt := r.cfg.renderPrompt(PromptData{Text: message.Text, User: message.User, Channel: channelId}) + fileNotes(message.Files, attached)
+	// Thread context and images change the answer, so only standalone
+	// text questions are cached.
+	cacheKey := ""
+	if message.ThreadTs == "" && len(opts.Images) == 0 {
+		cacheKey = answerCacheKey(opts, prompt)
+	}
+
+	resp, cached := r.cachedAnswer(cacheKey)
+	var err error
+	var latency time.Duration
+	switch {
+	case cached:
+		slog.Info("Reusing cached answer for repeated question", "channel", channelId, "ts", message.Ts, "user", message.User)
+	case r.cfg.DryRunStubChatGpt:
+		resp = DryRunStubAnswer
+	default:
+		start := time.Now()
+		resp, err = r.cfg.Chat.Send(ctx, history, prompt, opts)
+		latency = time.Since(start)
+		chatGptLatency.Observe(latency.Seconds())
+		r.record(func(s *RunSummary) { s.ChatGptLatencySeconds += latency.Seconds() })
+		if err != nil {
+			chatGptErrors.WithLabelValues(channelId).Inc()
+			r.record(func(s *RunSummary) { s.Errors++ })
+		} else if cacheKey != "" {
+			r.mu.Lock()
+			r.answerCache[cacheKey] = resp
+			r.mu.Unlock()
+		}
+	}
+	if err != nil {
+		r.reportFailure(ctx, channelId, replyChannel, threadTs, placeholderTs, message)
+	}
+	if errors.Is(err, ErrChatGptRetriesExhausted) || errors.Is(err, ErrCircuitOpen) {
+		slog.Error("ChatGPT is unavailable, skipping message", "channel", channelId, "ts", message.Ts, "user", message.User, "class", errorClass(err), "error", err)
+		return err
+	}
+	if err != nil {
+		slog.Error("Error sending message to ChatGPT", "channel", channelId, "ts", message.Ts, "user", message.User, "class", errorClass(err), "error", err)
+		return err
+	}
+
+	resp = r.cfg.formatAnswer(resp)
+	if truncated, ok := truncateReply(resp, r.cfg.MaxReplyChars); ok {
+		slog.Info("Truncated long reply", "channel", channelId, "ts", message.Ts, "chars", utf8.RuneCountInString(resp), "max_reply_chars", r.cfg.MaxReplyChars)
+		resp = truncated
+	}
+
+	// The mention leads the text, so only the first chunk carries it.
+	respWithMention := fmt.Sprintf("<@%s>\n%s", message.User, resp)
+	if r.cfg.GreetByName {
+		if name := r.userName(ctx, message.User); name != "" {
+			respWithMention = fmt.Sprintf("<@%s>\nHi %s,\n%s", message.User, name, resp)
+		}
+	}
+	limit := SlackMessageLimit
+	if r.cfg.UseBlockKit {
+		limit = SlackSectionTextLimit
+	}
+	chunks := appendFooter(splitMessage(respWithMention, limit), r.cfg.ReplyFooter, limit)
+
+	if r.cfg.DryRun {
+		slog.Info("Dry run, not posting reply", "channel", channelId, "thread_ts", threadTs, "dm", r.cfg.ReplyInDm, "user", message.User, "chunks", len(chunks))
+		r.printDryRun(fmt.Sprintf("reply in %s, thread %s:", channelId, threadTs), strings.Join(chunks, "\n"))
+		return nil
+	}
+
+	err = r.postChunks(ctx, replyChannel, threadTs, placeholderTs, model, chunks)
+	if err != nil {
+		slog.Error("Error posting to Slack thread", "channel", channelId, "ts", message.Ts, "user", message.User, "class", errorClass(err), "error", err)
+		slackErrors.WithLabelValues(channelId, "post").Inc()
+		r.record(func(s *RunSummary) { s.Errors++ })
+		return err
+	}
+
+	slog.Info("Post Slack Thread Done", "channel", channelId, "ts", message.Ts, "user", message.User, "chunks", len(chunks))
+	answersPosted.WithLabelValues(channelId).Inc()
+	r.record(func(s *RunSummary) { s.AnswersPosted++ })
+	r.cfg.Notifier.Notify(AnswerNotification{
+		Channel:   channelId,
+		Ts:        message.Ts,
+		User:      message.User,
+		Question:  message.Text,
+		Answer:    resp,
+		Model:     model,
+		LatencyMs: latency.Milliseconds(),
+	})
+	r.addReaction(ctx, channelId, message.Ts, r.cfg.DoneReaction)
+	r.markAnswered(message)
+	return nil
+}
+
+// markAnswered records message as answered and saves the state file,
+// unless the answer was only a preview.
+func (r *runner) markAnswered(message SlackMessage) {
+	r.mu.Lock()
+	r.state.Answered[message.Ts] = true
+	// Marking the thread keeps later questions in it from being answered
+	// on the next pass.
+	if r.cfg.OneReplyPerThread {
+		r.state.Answered[effectiveThreadTs(message)] = true
+	}
+	if r.cfg.Preview {
+		r.mu.Unlock()
+		return
+	}
+	err := saveState(r.cfg.StateFile, r.state)
+	r.mu.Unlock()
+	if err != nil {
+		slog.Error("Error saving state file", "path", r.cfg.StateFile, "error", err)
+	}
+}
+
+// reportFailure cleans up after ChatGPT could not answer. With
+// OnErrorNotify it tells the asker by posting ErrorMessage to the thread,
+// or by editing the placeholder into it when there is one. The notice is
+// tried once; if it fails too the failure is only logged. A posted notice
+// counts as the answer, so the asker is not told again on the next pass.
+// Otherwise the placeholder is deleted, so retries on later passes do not
+// leave one behind each.
+func (r *runner) reportFailure(ctx context.Context, channelId, replyChannel, threadTs, placeholderTs string, message SlackMessage) {
+	if r.cfg.OnError != OnErrorNotify {
+		if placeholderTs != "" {
+			r.deletePlaceholder(ctx, replyChannel, placeholderTs)
+		}
+		return
+	}
+
+	text := fmt.Sprintf("<@%s>\n%s", message.User, r.cfg.ErrorMessage)
+	if r.cfg.DryRun {
+		slog.Info("Dry run, not posting error notice", "channel", channelId, "thread_ts", threadTs, "user", message.User)
+		r.printDryRun(fmt.Sprintf("error notice in %s, thread %s:", channelId, threadTs), text)
+		return
+	}
+	var err error
+	if placeholderTs != "" {
+		err = r.cfg.Slack.UpdateMessage(ctx, replyChannel, placeholderTs, text, nil)
+	} else {
+		_, err = r.cfg.Slack.PostToThread(ctx, replyChannel, threadTs, text)
+	}
+	if err != nil {
+		slog.Error("Error posting error notice", "channel", channelId, "ts", message.Ts, "user", message.User, "class", errorClass(err), "error", err)
+		slackErrors.WithLabelValues(channelId, "notify").Inc()
+		return
+	}
+	r.markAnswered(message)
+}
+
+// printDryRun prints header and then text, every line prefixed with
+// "[DRY RUN] ", to DryRunOutput. It is one write, so the lines of answers
+// printed concurrently do not interleave.
+func (r *runner) printDryRun(header, text string) {
+	out := r.cfg.DryRunOutput
+	if out == nil {
+		out = os.Stdout
+	}
+	var b strings.Builder
+	for _, line := range strings.Split(header+"\n"+text, "\n") {
+		b.WriteString("[DRY RUN] " + line + "\n")
+	}
+	_, err := io.WriteString(out, b.String())
+	if err != nil {
+		slog.Error("Error printing dry run output", "error", err)
+	}
+}
+
+// deletePlaceholder removes the placeholder, logging failures: the
+// placeholder is a courtesy, so a failed delete never fails the answer.
+func (r *runner) deletePlaceholder(ctx context.Context, channelId, ts string) {
+	err := r.cfg.Slack.DeleteMessage(ctx, channelId, ts)
+	if err != nil {
+		slog.Warn("Error deleting placeholder", "channel", channelId, "ts", ts, "error", err)
+		slackErrors.WithLabelValues(channelId, "delete").Inc()
+	}
+}
+
+// cachedAnswer returns the answer cached under key, if any.
+func (r *runner) cachedAnswer(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	answer, ok := r.answerCache[key]
+	return answer, ok
+}
+
+// answerCacheKey hashes the question after trimming and lowercasing it,
+// so trivially different reposts share a cache entry. Channels with
+// their own model or prompt get their own entries.
+func answerCacheKey(opts ChatOptions, text string) string {
+	sum := sha256.Sum256([]byte(opts.Model + "\x00" + opts.SystemPrompt + "\x00" + strings.ToLower(strings.TrimSpace(text))))
+	return hex.EncodeToString(sum[:])
+}
+
+// window returns the history window to scan: from Lookback before the end
+// (or 20:00 yesterday in Location) up to now or LatestOverride.
+func (cfg Config) window(now time.Time) (oldest, latest time.Time, err error) {
+	latest = now
+	if !cfg.LatestOverride.IsZero() {
+		latest = cfg.LatestOverride
+	}
+
+	if cfg.Lookback > 0 {
+		return latest.Add(-cfg.Lookback), latest, nil
+	}
+
+	if cfg.Location == nil {
+		return time.Time{}, time.Time{}, errors.New("no timezone configured")
+	}
+	yesterday := latest.In(cfg.Location).AddDate(0, 0, -1)
+	oldest = time.Date(yesterday.Year(), yesterday.Month(), yesterday.Day(), 20, 0, 0, 0, cfg.Location)
+	return oldest, latest, nil
+}
+
+// addReaction reacts to the message with the named emoji. Failures are
+// only logged since the reaction is cosmetic.
+func (r *runner) addReaction(ctx context.Context, channelId, ts, name string) {
+	if name == "" {
+		return
+	}
+
+	err := r.cfg.Slack.AddReaction(ctx, channelId, ts, name)
+	if err != nil {
+		slog.Warn("Error adding reaction", "channel", channelId, "ts", ts, "reaction", name, "error", err)
+	}
+}
+
+// userName returns the display name of userId, looking it up once per
+// run. It returns "" when the lookup fails or the user is deactivated, so
+// the reply falls back to the bare mention.
+func (r *runner) userName(ctx context.Context, userId string) string {
+	r.mu.Lock()
+	name, ok := r.userNames[userId]
+	r.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	name, err := r.cfg.Slack.FetchUserInfo(ctx, userId)
+	if err != nil {
+		slog.Warn("Error fetching user info", "user", userId, "error", err)
+	}
+	r.mu.Lock()
+	r.userNames[userId] = name
+	r.mu.Unlock()
+	return name
+}
+
+// postChunks posts each chunk to the thread in order, stopping at the
+// first failure. With UseBlockKit only the last chunk names model, the
+// one that wrote the answer. When placeholderTs is set the first chunk
+// replaces the placeholder.
+func (r *runner) postChunks(ctx context.Context, channelId, threadTs, placeholderTs, model string, chunks []string) error {
+	for i, chunk := range chunks {
+		var blocks []map[string]interface{}
+		if r.cfg.UseBlockKit {
+			label := ""
+			if i == len(chunks)-1 {
+				label = model
+			}
+			blocks = replyBlocks(chunk, label)
+		}
+
+		var err error
+		switch {
+		case i == 0 && placeholderTs != "":
+			err = r.cfg.Slack.UpdateMessage(ctx, channelId, placeholderTs, chunk, blocks)
+		case blocks != nil:
+			_, err = r.cfg.Slack.PostBlocks(ctx, channelId, threadTs, chunk, blocks)
+		default:
+			_, err = r.cfg.Slack.PostToThread(ctx, channelId, threadTs, chunk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shouldAnswer reports whether message is a question from a person that
+// the bot has not answered yet. Replies in its thread are checked
+// separately by threadNeedsAnswer.
+func (r *runner) shouldAnswer(channelId string, message SlackMessage) bool {
+	if !r.isHumanMessage(message) || !r.cfg.userAllowed(message.User) || !r.cfg.forChannel(channelId).isQuestion(message.Text) {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.state.Answered[message.Ts]
+}
+
+// alreadyAnsweredByBot reports whether replies, the thread at threadTs,
+// include a reply from botId that mentions user first, as every answer
+// does. Unlike the state file, this survives restarts on a new machine.
+func alreadyAnsweredByBot(replies []SlackMessage, threadTs, user, botId string) bool {
+	mention := fmt.Sprintf("<@%s>", user)
+	for _, reply := range replies {
+		if reply.Ts != threadTs && reply.User == botId && strings.HasPrefix(reply.Text, mention) {
+			return true
+		}
+	}
+	return false
+}
+
+// threadNeedsAnswer looks at replies, the thread under message. With
+// AnswerIfHumanReplied only an earlier bot reply means the question is
+// taken; without it, so does a reply from someone other than the asker. Earlier answers to the asker are caught by alreadyAnsweredByBot.
+func (r *runner) threadNeedsAnswer(channelId string, message SlackMessage, replies []SlackMessage) bool {
+	for _, reply := range replies {
+		if reply.Ts == message.Ts {
+			continue
+		}
+		if reply.User == r.botUserId {
+			if r.cfg.AnswerIfHumanReplied {
+				return false
+			}
+			continue
+		}
+		if !r.cfg.AnswerIfHumanReplied && r.isHumanMessage(reply) && reply.User != message.User {
+			slog.Debug("Skipping question a person already replied to", "channel", channelId, "ts", message.Ts, "replier", reply.User)
+			return false
+		}
+	}
+	return true
+}
+
+// isHumanMessage reports whether m is an ordinary user post: not written
+// by the bot itself, and not a bot or system message such as a channel
+// join. Thread broadcasts and file shares still count as user posts.
+//
+// Edits and deletions never count. An edited message keeps its original
+// ts and is judged by its current text, so answering it is tracked under
+// the same ts as before the edit. The message_changed and
+// message_deleted notices, and the tombstones left in place of deleted
+// thread parents, are skipped.
+func (r *runner) isHumanMessage(m SlackMessage) bool {
+	if m.Type != "message" || m.Hidden || m.User == r.botUserId || m.BotId != "" {
+		return false
+	}
+
+	switch m.Subtype {
+	case "", "thread_broadcast", "file_share":
+		return true
+	default:
+		return false
+	}
+}
+
+// codeBlockPattern matches a ``` code block, including its fences.
+var codeBlockPattern = regexp.MustCompile("(?s)```.*?```")
+
+// isQuestion reports whether s matches QuestionRegex when it is set,
+// otherwise whether s contains any of QuestionKeywords.
+// An empty keyword list matches nothing. With IgnoreCodeBlocks only the
+// prose outside code blocks is considered.
+func (cfg Config) isQuestion(s string) bool {
+	if cfg.IgnoreCodeBlocks {
+		s = codeBlockPattern.ReplaceAllString(s, "")
+	}
+
+	if cfg.QuestionRegex != nil {
+		return cfg.QuestionRegex.MatchString(s)
+	}
+
+	for _, keyword := range cfg.QuestionKeywords {
+		if strings.Contains(s, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// tooOld reports whether m was posted more than MaxAge before now. A ts
+// that cannot be parsed is never too old.
+func (cfg Config) tooOld(m SlackMessage, now time.Time) bool {
+	if cfg.MaxAge <= 0 {
+		return false
+	}
+	posted, err := parseTime(m.Ts)
+	if err != nil {
+		return false
+	}
+	return now.Sub(posted) > cfg.MaxAge
+}
+
+// userAllowed reports whether questions from userId are answered.
+// IgnoredUsers always wins; otherwise a non-empty AllowedUsers must list
+// the user.
+func (cfg Config) userAllowed(userId string) bool {
+	if slices.Contains(cfg.IgnoredUsers, userId) {
+		return false
+	}
+	return len(cfg.AllowedUsers) == 0 || slices.Contains(cfg.AllowedUsers, userId)
+}
+
+// cursor returns where channelId's history was last handled up to.
+func (r *runner) cursor(channelId string) (time.Time, bool) {
+	r.mu.Lock()
+	ts, ok := r.state.Cursors[channelId]
+	r.mu.Unlock()
+	if !ok {
+		return time.Time{}, false
+	}
+
+	seconds, err := strconv.ParseFloat(ts, 64)
+	if err != nil {
+		slog.Warn("Ignoring invalid cursor in state file", "channel", channelId, "cursor", ts)
+		return time.Time{}, false
+	}
+	// Slack's oldest has whole-second precision here, so the cursor's own
+	// message is fetched again and skipped as answered.
+	return time.Unix(int64(seconds), 0), true
+}
+
+// advanceCursor records that channelId is handled up to pending, the ts
+// of the first question still to answer, or up to latest when pending is
+// empty. Dry runs and previews leave the cursor alone, as they do the
+// saved answered set.
+func (r *runner) advanceCursor(channelId string, latest time.Time, pending string) {
+	if r.cfg.DryRun || r.cfg.Preview {
+		return
+	}
+	ts := pending
+	if ts == "" {
+		ts = fmt.Sprintf("%d.000000", latest.Unix())
+	}
+
+	r.mu.Lock()
+	r.state.Cursors[channelId] = ts
+	err := saveState(r.cfg.StateFile, r.state)
+	r.mu.Unlock()
+	if err != nil {
+		slog.Error("Error saving state file", "path", r.cfg.StateFile, "error", err)
+	}
+}
+
+// firstPerThread keeps the earliest of the sorted messages in each thread
+// that has not been answered yet, logging the rest as skipped.
+func (r *runner) firstPerThread(channelId string, messages []SlackMessage) []SlackMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen := map[string]bool{}
+	var first []SlackMessage
+	for _, message := range messages {
+		thread := effectiveThreadTs(message)
+		if seen[thread] || r.state.Answered[thread] {
+			slog.Info("Skipping question, thread already has one", "channel", channelId, "ts", message.Ts, "thread_ts", thread)
+			continue
+		}
+		seen[thread] = true
+		first = append(first, message)
+	}
+	return first
+}
+
+// earliestTs returns the earlier of two ts, ignoring empty ones.
+func earliestTs(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	sorted := []SlackMessage{{Ts: a}, {Ts: b}}
+	sortMessagesByTs(sorted)
+	return sorted[0].Ts
+}
+
+// sortMessagesByTs orders msgs oldest first. Messages whose ts does not
+// parse sort last, in their original order, so a malformed message never
+// jumps ahead of real questions.
+func sortMessagesByTs(msgs []SlackMessage) {
+	sort.SliceStable(msgs, func(i, j int) bool {
+		tsi, erri := strconv.ParseFloat(msgs[i].Ts, 64)
+		tsj, errj := strconv.ParseFloat(msgs[j].Ts, 64)
+		if erri != nil || errj != nil {
+			return erri == nil && errj != nil
+		}
+		return tsi < tsj
+	})
+}
+
+// effectiveThreadTs returns the ts to reply under: the message's thread
+// when it is part of one, otherwise the message itself.
+func effectiveThreadTs(m SlackMessage) string {
+	if m.ThreadTs != "" {
+		return m.ThreadTs
+	}
+	return m.Ts
+}
+
+// threadHistory turns the thread messages posted before currentTs into
+// chat context, keeping at most the last limit of them. Messages from bots
+// become assistant turns and everything else is a user turn.
+func threadHistory(replies []SlackMessage, currentTs string, limit int) []ChatMessage {
+	var history []ChatMessage
+	for _, reply := range replies {
+		if reply.Ts == currentTs {
+			break
+		}
+
+		role := "user"
+		if reply.BotId != "" {
+			role = "assistant"
+		}
+		history = append(history, ChatMessage{Role: role, Content: reply.Text})
+	}
+
+	if len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+	return history
+}
+
+// jitter returns d shifted by a random amount of up to percent of d in
+// either direction. The math/rand global source is seeded randomly at
+// startup, so separate processes get different sequences.
+func jitter(d time.Duration, percent int) time.Duration {
+	if d <= 0 || percent <= 0 {
+		return d
+	}
+	spread := float64(d) * float64(percent) / 100
+	return d + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// requestContext derives the context for one HTTP request, cancelled
+// after timeout when it is positive.
+func requestContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// sleepContext waits for d, returning early with ctx's error if ctx is
+// cancelled first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testBotUserId = "UBOT"
+
+// fakePost is a message the bot posted, or an edit to one.
+type fakePost struct {
+	Channel  string
+	ThreadTs string
+	Ts       string
+	Text     string
+	Blocks   []map[string]interface{}
+}
+
+// fakeSlack is an in-memory SlackClient. Messages are returned by
+// channel and, like conversations.history, only from oldest on; thread
+// replies by thread ts. Posts and edits are recorded.
+type fakeSlack struct {
+	mu       sync.Mutex
+	messages map[string][]SlackMessage
+	replies  map[string][]SlackMessage
+	posts    []fakePost
+	updates  []fakePost
+	deletes  []fakePost
+	// replyFetches counts FetchThreadReplies calls by thread ts.
+	replyFetches map[string]int
+}
+
+func newFakeSlack() *fakeSlack {
+	return &fakeSlack{
+		messages:     map[string][]SlackMessage{},
+		replies:      map[string][]SlackMessage{},
+		replyFetches: map[string]int{},
+	}
+}
+
+func (f *fakeSlack) FetchMessages(ctx context.Context, channelId string, oldest, latest time.Time) ([]SlackMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var messages []SlackMessage
+	for _, message := range f.messages[channelId] {
+		if posted, err := parseTime(message.Ts); err != nil || !posted.Before(oldest) {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+func (f *fakeSlack) FetchThreadReplies(ctx context.Context, channelId, threadTs string) ([]SlackMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replyFetches[threadTs]++
+	return append([]SlackMessage(nil), f.replies[threadTs]...), nil
+}
+
+func (f *fakeSlack) PostToThread(ctx context.Context, channelId, threadTs, message string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ts := fmt.Sprintf("1900000000.%06d", len(f.posts)+1)
+	f.posts = append(f.posts, fakePost{Channel: channelId, ThreadTs: threadTs, Ts: ts, Text: message})
+	return ts, nil
+}
+
+func (f *fakeSlack) PostBlocks(ctx context.Context, channelId, threadTs, text string, blocks []map[string]interface{}) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ts := fmt.Sprintf("1900000000.%06d", len(f.posts)+1)
+	f.posts = append(f.posts, fakePost{Channel: channelId, ThreadTs: threadTs, Ts: ts, Text: text, Blocks: blocks})
+	return ts, nil
+}
+
+func (f *fakeSlack) UpdateMessage(ctx context.Context, channelId, ts, text string, blocks []map[string]interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updates = append(f.updates, fakePost{Channel: channelId, Ts: ts, Text: text})
+	return nil
+}
+
+func (f *fakeSlack) DeleteMessage(ctx context.Context, channelId, ts string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deletes = append(f.deletes, fakePost{Channel: channelId, Ts: ts})
+	return nil
+}
+
+func (f *fakeSlack) FetchBotUserId(ctx context.Context) (string, error) {
+	return testBotUserId, nil
+}
+
+func (f *fakeSlack) FetchUserInfo(ctx context.Context, userId string) (string, error) {
+	return "", nil
+}
+
+func (f *fakeSlack) AddReaction(ctx context.Context, channelId, ts, name string) error {
+	return nil
+}
+
+func (f *fakeSlack) OpenSocketConnection(ctx context.Context) (string, error) {
+	return "", fmt.Errorf("not supported by fakeSlack")
+}
+
+func (f *fakeSlack) OpenDirectMessage(ctx context.Context, userId string) (string, error) {
+	return "D" + userId, nil
+}
+
+func (f *fakeSlack) DownloadFile(ctx context.Context, url string) ([]byte, error) {
+	return nil, fmt.Errorf("not supported by fakeSlack")
+}
+
+func (f *fakeSlack) RespondToCommand(ctx context.Context, responseUrl string, payload map[string]interface{}) error {
+	return nil
+}
+
+func (f *fakeSlack) postsTo(threadTs string) []fakePost {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var posts []fakePost
+	for _, post := range f.posts {
+		if post.ThreadTs == threadTs {
+			posts = append(posts, post)
+		}
+	}
+	return posts
+}
+
+// fakeChat is a ChatClient that records prompts and histories and
+// answers each with "answer to <prompt>", or fails with err when it is
+// set.
+type fakeChat struct {
+	mu        sync.Mutex
+	prompts   []string
+	histories [][]ChatMessage
+	err       error
+}
+
+func (f *fakeChat) Send(ctx context.Context, history []ChatMessage, prompt string, opts ChatOptions) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prompts = append(f.prompts, prompt)
+	f.histories = append(f.histories, history)
+	if f.err != nil {
+		return "", f.err
+	}
+	return "answer to " + prompt, nil
+}
+
+func (f *fakeChat) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.prompts)
+}
+
+// newTestConfig returns a single-pass configuration watching channel C1
+// with the default question keyword, talking to the given fakes.
+func newTestConfig(slack SlackClient, chat ChatClient) Config {
+	return Config{
+		ChannelIds:         []string{"C1"},
+		QuestionKeywords:   []string{DefaultQuestionKeywords},
+		AnswerLimit:        AnswerLimit,
+		Concurrency:        1,
+		ThreadHistoryLimit: DefaultThreadHistoryLimit,
+		Lookback:           time.Hour,
+		Location:           time.UTC,
+		ErrorMessage:       DefaultErrorMessage,
+		Slack:              slack,
+		Chat:               chat,
+	}
+}
+
+// recentTs returns the ts of a message posted offset ago.
+func recentTs(offset time.Duration) string {
+	return fmt.Sprintf("%d.000100", time.Now().Add(-offset).Unix())
+}
+
+func TestRunAnswersQuestions(t *testing.T) {
+	slack := newFakeSlack()
+	first, second, answered := recentTs(3*time.Minute), recentTs(2*time.Minute), recentTs(time.Minute)
+	slack.messages["C1"] = []SlackMessage{
+		// Newest first, as conversations.history returns them.
+		{Type: "message", User: "U2", Text: "質問です B", Ts: second},
+		{Type: "message", User: "U1", Text: "質問です A", Ts: first},
+		{Type: "message", User: "U3", Text: "おはようございます", Ts: recentTs(90 * time.Second)},
+		{Type: "message", BotId: "B1", Text: "質問です from a bot", Ts: recentTs(80 * time.Second)},
+		{Type: "message", User: "U4", Text: "質問です C", Ts: answered, ReplyCount: 1},
+	}
+	slack.replies[answered] = []SlackMessage{
+		{Type: "message", User: "U4", Text: "質問です C", Ts: answered},
+		{Type: "message", User: testBotUserId, Text: "<@U4>\nearlier answer", Ts: recentTs(30 * time.Second), ThreadTs: answered},
+	}
+	chat := &fakeChat{}
+
+	err := Run(context.Background(), newTestConfig(slack, chat))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if want := []string{"質問です A", "質問です B"}; strings.Join(chat.prompts, "|") != strings.Join(want, "|") {
+		t.Errorf("prompts = %q, want %q in ts order", chat.prompts, want)
+	}
+	for _, tt := range []struct{ ts, user, question string }{{first, "U1", "質問です A"}, {second, "U2", "質問です B"}} {
+		posts := slack.postsTo(tt.ts)
+		if len(posts) != 1 {
+			t.Errorf("posts to %s = %+v, want one answer", tt.ts, posts)
+			continue
+		}
+		if want := fmt.Sprintf("<@%s>\nanswer to %s", tt.user, tt.question); posts[0].Text != want {
+			t.Errorf("answer in %s = %q, want %q", tt.ts, posts[0].Text, want)
+		}
+	}
+	if posts := slack.postsTo(answered); len(posts) != 0 {
+		t.Errorf("answered an already answered question again: %+v", posts)
+	}
+}
+
+func TestRunLabelsAnswerWithChannelModel(t *testing.T) {
+	slack := newFakeSlack()
+	ts := recentTs(time.Minute)
+	slack.messages["C1"] = []SlackMessage{{Type: "message", User: "U1", Text: "質問です", Ts: ts}}
+	cfg := newTestConfig(slack, &fakeChat{})
+	cfg.Model = DefaultChatGptModel
+	cfg.UseBlockKit = true
+	cfg.Channels = map[string]ChannelConfig{"C1": {Model: "gpt-4o"}}
+
+	err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	posts := slack.postsTo(ts)
+	if len(posts) != 1 {
+		t.Fatalf("posts = %+v, want one answer", posts)
+	}
+	if want := replyBlocks(posts[0].Text, "gpt-4o"); fmt.Sprint(posts[0].Blocks) != fmt.Sprint(want) {
+		t.Errorf("blocks = %v, want them to name the channel's model gpt-4o", posts[0].Blocks)
+	}
+}
+
+func TestRunDryRunPrintsReply(t *testing.T) {
+	slack := newFakeSlack()
+	ts := recentTs(time.Minute)
+	slack.messages["C1"] = []SlackMessage{{Type: "message", User: "U1", Text: "質問です", Ts: ts}}
+	var out strings.Builder
+	cfg := newTestConfig(slack, &fakeChat{})
+	cfg.DryRun = true
+	cfg.DryRunOutput = &out
+
+	err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(slack.posts) != 0 {
+		t.Errorf("posts = %+v, want none in a dry run", slack.posts)
+	}
+	want := fmt.Sprintf("[DRY RUN] reply in C1, thread %s:\n[DRY RUN] <@U1>\n[DRY RUN] answer to 質問です\n", ts)
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestRunStopsAtAnswerLimit(t *testing.T) {
+	slack := newFakeSlack()
+	for i := 0; i < 15; i++ {
+		slack.messages["C1"] = append(slack.messages["C1"], SlackMessage{
+			Type: "message",
+			User: "U1",
+			Text: fmt.Sprintf("質問です %d", i),
+			Ts:   recentTs(time.Duration(30-i) * time.Minute),
+		})
+	}
+	chat := &fakeChat{}
+
+	err := Run(context.Background(), newTestConfig(slack, chat))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if got := chat.calls(); got != AnswerLimit {
+		t.Errorf("ChatGPT calls = %d, want %d", got, AnswerLimit)
+	}
+	if got := len(slack.posts); got != AnswerLimit {
+		t.Errorf("posts = %d, want %d", got, AnswerLimit)
+	}
+}
+
+func TestRunPlaceholderOnChatGptFailure(t *testing.T) {
+	for _, onError := range []string{OnErrorSkip, OnErrorNotify} {
+		t.Run(onError, func(t *testing.T) {
+			slack := newFakeSlack()
+			question := recentTs(time.Minute)
+			slack.messages["C1"] = []SlackMessage{{Type: "message", User: "U1", Text: "質問です", Ts: question}}
+			chat := &fakeChat{err: ErrTransient}
+			cfg := newTestConfig(slack, chat)
+			cfg.UsePlaceholder = true
+			cfg.OnError = onError
+			cfg.StateFile = t.TempDir() + "/state.json"
+
+			for pass := 0; pass < 3; pass++ {
+				err := Run(context.Background(), cfg)
+				if err != nil {
+					t.Fatalf("Run() error = %v", err)
+				}
+			}
+
+			posts := slack.postsTo(question)
+			switch onError {
+			case OnErrorSkip:
+				if len(posts) != 3 || len(slack.deletes) != 3 {
+					t.Errorf("posted %d placeholders and deleted %d over 3 passes, want 3 of each", len(posts), len(slack.deletes))
+				}
+				if len(slack.updates) != 0 {
+					t.Errorf("updates = %+v, want none", slack.updates)
+				}
+			case OnErrorNotify:
+				if len(posts) != 1 || chat.calls() != 1 {
+					t.Fatalf("posts = %+v after %d ChatGPT calls, want one placeholder and one call", posts, chat.calls())
+				}
+				want := "<@U1>\n" + DefaultErrorMessage
+				if len(slack.updates) != 1 || slack.updates[0].Ts != posts[0].Ts || slack.updates[0].Text != want {
+					t.Errorf("updates = %+v, want the placeholder edited to %q", slack.updates, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunPreviewDoesNotCountAsAnswered(t *testing.T) {
+	slack := newFakeSlack()
+	question := recentTs(time.Minute)
+	slack.messages["C1"] = []SlackMessage{{Type: "message", User: "U1", Text: "質問です", Ts: question}}
+	cfg := newTestConfig(slack, &fakeChat{})
+	cfg.StateFile = t.TempDir() + "/state.json"
+	cfg.Preview = true
+
+	err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	state, err := loadState(cfg.StateFile)
+	if err != nil {
+		t.Fatalf("loadState() error = %v", err)
+	}
+	if len(state.Answered) != 0 || len(state.Cursors) != 0 {
+		t.Errorf("state after a preview = %+v, want nothing saved", state)
+	}
+
+	cfg.Preview = false
+	err = Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if posts := slack.postsTo(question); len(posts) != 2 {
+		t.Errorf("posts = %+v, want the preview and then the public answer", posts)
+	}
+}
+
+func TestRunRetriesOnlyTransientFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantCalls int
+	}{
+		{name: "outage", err: ErrTransient, wantCalls: 3},
+		{name: "rate limited", err: ErrRateLimit, wantCalls: 3},
+		{name: "circuit open", err: ErrCircuitOpen, wantCalls: 3},
+		{name: "permanent", err: errors.New("invalid_request_error: context too long"), wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := newFakeSlack()
+			slack.messages["C1"] = []SlackMessage{{Type: "message", User: "U1", Text: "質問です", Ts: recentTs(time.Minute)}}
+			chat := &fakeChat{err: tt.err}
+			cfg := newTestConfig(slack, chat)
+			cfg.StateFile = t.TempDir() + "/state.json"
+
+			for pass := 0; pass < 3; pass++ {
+				err := Run(context.Background(), cfg)
+				if err != nil {
+					t.Fatalf("Run() error = %v", err)
+				}
+			}
+
+			if got := chat.calls(); got != tt.wantCalls {
+				t.Errorf("ChatGPT calls over 3 passes = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestAlreadyAnsweredByBot(t *testing.T) {
+	question := SlackMessage{Type: "message", User: "U1", Text: "質問です", Ts: "1.0"}
+	tests := []struct {
+		name  string
+		reply SlackMessage
+		want  bool
+	}{
+		{
+			name:  "bot answered the asker",
+			reply: SlackMessage{Type: "message", User: testBotUserId, Text: "<@U1>\nanswer", Ts: "2.0"},
+			want:  true,
+		},
+		{
+			name:  "bot answered someone else",
+			reply: SlackMessage{Type: "message", User: testBotUserId, Text: "<@U2>\nanswer", Ts: "2.0"},
+			want:  false,
+		},
+		{
+			name:  "only a person replied",
+			reply: SlackMessage{Type: "message", User: "U2", Text: "<@U1> try this", Ts: "2.0"},
+			want:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := alreadyAnsweredByBot([]SlackMessage{question, tt.reply}, "1.0", "U1", testBotUserId)
+			if got != tt.want {
+				t.Errorf("alreadyAnsweredByBot() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunFetchesEachThreadOnce(t *testing.T) {
+	slack := newFakeSlack()
+	question := recentTs(time.Minute)
+	slack.messages["C1"] = []SlackMessage{{Type: "message", User: "U1", Text: "質問です", Ts: question, ThreadTs: question, ReplyCount: 1}}
+	slack.replies[question] = []SlackMessage{
+		{Type: "message", User: "U1", Text: "質問です", Ts: question, ThreadTs: question},
+		{Type: "message", User: "U1", Text: "補足です", Ts: recentTs(30 * time.Second), ThreadTs: question},
+	}
+	chat := &fakeChat{}
+
+	err := Run(context.Background(), newTestConfig(slack, chat))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if chat.calls() != 1 {
+		t.Errorf("ChatGPT calls = %d, want 1", chat.calls())
+	}
+	if got := slack.replyFetches[question]; got != 1 {
+		t.Errorf("conversations.replies calls = %d, want 1", got)
+	}
+}
+
+func TestEffectiveThreadTs(t *testing.T) {
+	tests := []struct {
+		name    string
+		message SlackMessage
+		want    string
+	}{
+		{
+			name:    "top-level message",
+			message: SlackMessage{Ts: "1704150000.000100"},
+			want:    "1704150000.000100",
+		},
+		{
+			name:    "thread parent",
+			message: SlackMessage{Ts: "1704150000.000100", ThreadTs: "1704150000.000100"},
+			want:    "1704150000.000100",
+		},
+		{
+			name:    "reply in a thread",
+			message: SlackMessage{Ts: "1704150500.000200", ThreadTs: "1704150000.000100"},
+			want:    "1704150000.000100",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := effectiveThreadTs(tt.message); got != tt.want {
+				t.Errorf("effectiveThreadTs(%+v) = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+}
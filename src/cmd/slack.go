@@ -0,0 +1,856 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SlackApiBaseUrl = "https://slack.com/api/"
+	// DefaultMaxHistoryPages caps conversations.history pagination when
+	// SLACK_MAX_PAGES is not set.
+	DefaultMaxHistoryPages = 10
+	// DefaultMaxFetchMessages caps the messages one history fetch keeps
+	// when MAX_FETCH_MESSAGES is not set.
+	DefaultMaxFetchMessages = 1000
+	// DefaultSlackMaxRetries is how many times a rate-limited Slack request
+	// is retried when SLACK_MAX_RETRIES is not set.
+	DefaultSlackMaxRetries = 3
+	// SlackPostMaxAttempts bounds how often a post is tried when Slack is
+	// unreachable or failing with 5xx.
+	SlackPostMaxAttempts = 3
+	SlackPostRetryDelay  = time.Second
+)
+
+// SlackClient is the part of the Slack Web API that Run depends on.
+type SlackClient interface {
+	FetchMessages(ctx context.Context, channelId string, oldest, latest time.Time) ([]SlackMessage, error)
+	FetchThreadReplies(ctx context.Context, channelId, threadTs string) ([]SlackMessage, error)
+	PostToThread(ctx context.Context, channelId, threadTs, message string) (string, error)
+	PostBlocks(ctx context.Context, channelId, threadTs, text string, blocks []map[string]interface{}) (string, error)
+	UpdateMessage(ctx context.Context, channelId, ts, text string, blocks []map[string]interface{}) error
+	DeleteMessage(ctx context.Context, channelId, ts string) error
+	FetchBotUserId(ctx context.Context) (string, error)
+	FetchUserInfo(ctx context.Context, userId string) (string, error)
+	AddReaction(ctx context.Context, channelId, ts, name string) error
+	OpenSocketConnection(ctx context.Context) (string, error)
+	OpenDirectMessage(ctx context.Context, userId string) (string, error)
+	DownloadFile(ctx context.Context, url string) ([]byte, error)
+	RespondToCommand(ctx context.Context, responseUrl string, payload map[string]interface{}) error
+}
+
+// HttpSlackClient implements SlackClient against the real Slack Web API.
+type HttpSlackClient struct {
+	HttpClient *http.Client
+	// Timeout cancels each HTTP request to Slack that takes longer, so
+	// one hung call does not stall the run. Zero means no timeout.
+	Timeout time.Duration
+	Token   string
+	// AppToken is the app-level (xapp-) token used only to open Socket
+	// Mode connections.
+	AppToken string
+	// BaseUrl overrides SlackApiBaseUrl, e.g. to point tests at a fake
+	// Slack or to go through an Enterprise Grid proxy. A trailing slash is
+	// optional.
+	BaseUrl         string
+	MaxHistoryPages int
+	// MaxMessages stops pagination once this many messages are fetched,
+	// bounding memory on very busy channels. Zero means no cap.
+	MaxMessages int
+	MaxRetries  int
+	// EphemeralUser, when set, turns every post into an ephemeral message
+	// only that user can see, for previewing answers before going public.
+	EphemeralUser string
+	// PostRetryDelay is the first wait before retrying a failed post; it
+	// doubles on each retry. Zero means SlackPostRetryDelay.
+	PostRetryDelay time.Duration
+}
+
+// RateLimitError is returned when Slack is still rate limiting after
+// MaxRetries retries. RetryAfter is the last wait Slack asked for.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("slack API rate limited, retry after %s", e.RetryAfter)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimit
+}
+
+// SlackHttpError is returned when Slack answers with a non-2xx status
+// other than 429.
+type SlackHttpError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *SlackHttpError) Error() string {
+	return fmt.Sprintf("slack API HTTP error: %s", e.Status)
+}
+
+func (e *SlackHttpError) Is(target error) bool {
+	return target != nil && classifyStatus(e.StatusCode) == target
+}
+
+// SlackApiError is an "ok": false response from the Web API. Code is
+// Slack's error string, e.g. "channel_not_found".
+type SlackApiError struct {
+	Code   string
+	Needed string
+}
+
+func (e *SlackApiError) Error() string {
+	return fmt.Sprintf("slack API error: %s, needed: %s", e.Code, e.Needed)
+}
+
+// Is classifies the documented error codes that mean the token is
+// unusable, or that Slack itself is having trouble.
+func (e *SlackApiError) Is(target error) bool {
+	switch e.Code {
+	case "not_authed", "invalid_auth", "account_inactive", "token_revoked", "token_expired", "no_permission":
+		return target == ErrAuth
+	case "ratelimited":
+		return target == ErrRateLimit
+	case "internal_error", "fatal_error", "service_unavailable", "request_timeout":
+		return target == ErrTransient
+	}
+	return false
+}
+
+type SlackMessage struct {
+	Type       string `json:"type"`
+	Subtype    string `json:"subtype"`
+	User       string `json:"user"`
+	Text       string `json:"text"`
+	Ts         string `json:"ts"`
+	ThreadTs   string `json:"thread_ts"`
+	ReplyCount int    `json:"reply_count"`
+	BotId      string `json:"bot_id"`
+	Channel    string `json:"channel"`
+	// Files are the uploads attached to the message.
+	Files []SlackFile `json:"files"`
+	// Edited is set once the message has been edited; Ts stays that of
+	// the original post.
+	Edited *SlackEdited `json:"edited"`
+	// Hidden marks notices such as message_changed and message_deleted
+	// that clients do not show. PreviousMessage is the message as it was
+	// before such a change.
+	Hidden          bool          `json:"hidden"`
+	PreviousMessage *SlackMessage `json:"previous_message"`
+	// Message is the message as it is after a message_changed event.
+	Message *SlackMessage `json:"message"`
+	// DeletedTs is the ts of the message a message_deleted event removed.
+	DeletedTs string `json:"deleted_ts"`
+}
+
+type SlackEdited struct {
+	User string `json:"user"`
+	Ts   string `json:"ts"`
+}
+
+type SlackFile struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	Mimetype   string `json:"mimetype"`
+	Size       int    `json:"size"`
+	UrlPrivate string `json:"url_private"`
+}
+
+type SlackConversationsHistoryResponse struct {
+	Ok               bool           `json:"ok"`
+	Messages         []SlackMessage `json:"messages"`
+	HasMore          bool           `json:"has_more"`
+	ResponseMetadata struct {
+		NextCursor string `json:"next_cursor"`
+	} `json:"response_metadata"`
+	Error  string `json:"error"`
+	Needed string `json:"needed"`
+}
+
+type SlackConversationsRepliesResponse struct {
+	Ok       bool           `json:"ok"`
+	Messages []SlackMessage `json:"messages"`
+	Error    string         `json:"error"`
+	Needed   string         `json:"needed"`
+}
+
+type SlackAuthTestResponse struct {
+	Ok     bool   `json:"ok"`
+	UserId string `json:"user_id"`
+	Error  string `json:"error"`
+	Needed string `json:"needed"`
+}
+
+type SlackConnectionsOpenResponse struct {
+	Ok     bool   `json:"ok"`
+	Url    string `json:"url"`
+	Error  string `json:"error"`
+	Needed string `json:"needed"`
+}
+
+type SlackConversationsOpenResponse struct {
+	Ok      bool `json:"ok"`
+	Channel struct {
+		Id string `json:"id"`
+	} `json:"channel"`
+	Error  string `json:"error"`
+	Needed string `json:"needed"`
+}
+
+type SlackUsersInfoResponse struct {
+	Ok   bool `json:"ok"`
+	User struct {
+		Name    string `json:"name"`
+		Deleted bool   `json:"deleted"`
+		Profile struct {
+			DisplayName string `json:"display_name"`
+			RealName    string `json:"real_name"`
+		} `json:"profile"`
+	} `json:"user"`
+	Error  string `json:"error"`
+	Needed string `json:"needed"`
+}
+
+type SlackPostMessageResponse struct {
+	Ok      bool   `json:"ok"`
+	Ts      string `json:"ts"`
+	Channel string `json:"channel"`
+	Error   string `json:"error"`
+	Needed  string `json:"needed"`
+}
+
+// FetchMessages returns the channel's messages posted between oldest and
+// latest, following pagination up to MaxHistoryPages. If the bot is not
+// in the channel it joins once and retries, which works for public
+// channels only.
+func (c *HttpSlackClient) FetchMessages(ctx context.Context, channelId string, oldest, latest time.Time) ([]SlackMessage, error) {
+	var messages []SlackMessage
+	cursor := ""
+	joined := false
+	for page := 0; page < c.MaxHistoryPages; page++ {
+		apiResponse, err := c.fetchHistoryPage(ctx, channelId, oldest.Unix(), latest.Unix(), cursor)
+		var apiErr *SlackApiError
+		if !joined && errors.As(err, &apiErr) && apiErr.Code == "not_in_channel" {
+			joined = true
+			slog.Info("Bot is not in channel, joining", "channel", channelId)
+			if joinErr := c.joinChannel(ctx, channelId); joinErr != nil {
+				return nil, fmt.Errorf("bot is not a member of channel %s and could not join it; invite it with /invite @bot: %w", channelId, joinErr)
+			}
+			apiResponse, err = c.fetchHistoryPage(ctx, channelId, oldest.Unix(), latest.Unix(), cursor)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, apiResponse.Messages...)
+		slog.Debug("Fetched channel history page", "channel", channelId, "page", page+1, "messages", len(apiResponse.Messages))
+
+		// History comes newest first, so the cap drops the oldest.
+		if c.MaxMessages > 0 && len(messages) >= c.MaxMessages {
+			if len(messages) > c.MaxMessages || apiResponse.HasMore {
+				slog.Warn("Stopped fetching channel history at message cap, older messages are skipped", "channel", channelId, "max_fetch_messages", c.MaxMessages)
+			}
+			return messages[:c.MaxMessages], nil
+		}
+
+		cursor = apiResponse.ResponseMetadata.NextCursor
+		if !apiResponse.HasMore || cursor == "" {
+			return messages, nil
+		}
+	}
+
+	slog.Warn("Stopped fetching channel history at page limit", "channel", channelId, "pages", c.MaxHistoryPages)
+	return messages, nil
+}
+
+func (c *HttpSlackClient) fetchHistoryPage(ctx context.Context, channelId string, oldest, latest int64, cursor string) (*SlackConversationsHistoryResponse, error) {
+	url := fmt.Sprintf("%sconversations.history?channel=%s&oldest=%d&latest=%d", c.baseUrl(), channelId, oldest, latest)
+	if cursor != "" {
+		url += "&cursor=" + neturl.QueryEscape(cursor)
+	}
+
+	body, err := c.doRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var apiResponse SlackConversationsHistoryResponse
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	if !apiResponse.Ok {
+		return nil, &SlackApiError{Code: apiResponse.Error, Needed: apiResponse.Needed}
+	}
+
+	return &apiResponse, nil
+}
+
+func (c *HttpSlackClient) FetchThreadReplies(ctx context.Context, channelId, threadTs string) ([]SlackMessage, error) {
+	url := fmt.Sprintf("%sconversations.replies?channel=%s&ts=%s", c.baseUrl(), channelId, threadTs)
+
+	body, err := c.doRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var apiResponse SlackConversationsRepliesResponse
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	if !apiResponse.Ok {
+		return nil, &SlackApiError{Code: apiResponse.Error, Needed: apiResponse.Needed}
+	}
+
+	return apiResponse.Messages, nil
+}
+
+// PostToThread posts message to the thread and returns the posted
+// message's ts, for follow-up edits and reactions.
+func (c *HttpSlackClient) PostToThread(ctx context.Context, channelId, threadTs, message string) (string, error) {
+	return c.postMessage(ctx, map[string]interface{}{
+		"token":     c.Token,
+		"channel":   channelId,
+		"text":      message,
+		"thread_ts": threadTs,
+	})
+}
+
+// PostBlocks posts a Block Kit message to the thread. text is the plain
+// fallback shown in notifications and by clients without Block Kit.
+func (c *HttpSlackClient) PostBlocks(ctx context.Context, channelId, threadTs, text string, blocks []map[string]interface{}) (string, error) {
+	return c.postMessage(ctx, map[string]interface{}{
+		"channel":   channelId,
+		"text":      text,
+		"blocks":    blocks,
+		"thread_ts": threadTs,
+	})
+}
+
+// postMessage sends requestData to chat.postMessage, or to
+// chat.postEphemeral when EphemeralUser is set. Network errors and
+// 5xx responses are retried with backoff up to SlackPostMaxAttempts times;
+// other failures, such as channel_not_found, are returned at once.
+func (c *HttpSlackClient) postMessage(ctx context.Context, requestData map[string]interface{}) (string, error) {
+	url := fmt.Sprintf("%schat.postMessage", c.baseUrl())
+	if c.EphemeralUser != "" {
+		url = fmt.Sprintf("%schat.postEphemeral", c.baseUrl())
+		requestData["user"] = c.EphemeralUser
+	}
+	// An empty thread_ts posts to the channel itself.
+	if requestData["thread_ts"] == "" {
+		delete(requestData, "thread_ts")
+	}
+
+	jsonData, err := json.Marshal(requestData)
+	if err != nil {
+		return "", err
+	}
+
+	delay := c.PostRetryDelay
+	if delay <= 0 {
+		delay = SlackPostRetryDelay
+	}
+
+	var body []byte
+	for attempt := 1; ; attempt++ {
+		body, err = c.doRequest(ctx, func() (*http.Request, error) {
+			req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+			return req, nil
+		})
+		if err == nil {
+			break
+		}
+		if attempt >= SlackPostMaxAttempts || !isTransientSlackError(ctx, err) {
+			return "", err
+		}
+
+		slog.Warn("Posting to Slack failed, retrying", "channel", requestData["channel"], "attempt", attempt, "delay", delay, "error", err)
+		err = sleepContext(ctx, delay)
+		if err != nil {
+			return "", err
+		}
+		delay *= 2
+	}
+
+	var apiResponse SlackPostMessageResponse
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		return "", err
+	}
+
+	if !apiResponse.Ok {
+		return "", &SlackApiError{Code: apiResponse.Error, Needed: apiResponse.Needed}
+	}
+
+	slog.Debug("Posted message", "channel", requestData["channel"], "thread_ts", requestData["thread_ts"], "ts", apiResponse.Ts)
+	return apiResponse.Ts, nil
+}
+
+// DeleteMessage deletes the bot's message ts via chat.delete.
+func (c *HttpSlackClient) DeleteMessage(ctx context.Context, channelId, ts string) error {
+	url := fmt.Sprintf("%schat.delete", c.baseUrl())
+
+	jsonData, err := json.Marshal(map[string]string{
+		"channel": channelId,
+		"ts":      ts,
+	})
+	if err != nil {
+		return err
+	}
+
+	body, err := c.doRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var apiResponse SlackPostMessageResponse
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		return err
+	}
+
+	if !apiResponse.Ok {
+		return &SlackApiError{Code: apiResponse.Error, Needed: apiResponse.Needed}
+	}
+
+	return nil
+}
+
+// UpdateMessage replaces the text, and the blocks when non-nil, of the
+// bot's message ts via chat.update.
+func (c *HttpSlackClient) UpdateMessage(ctx context.Context, channelId, ts, text string, blocks []map[string]interface{}) error {
+	url := fmt.Sprintf("%schat.update", c.baseUrl())
+
+	requestData := map[string]interface{}{
+		"channel": channelId,
+		"ts":      ts,
+		"text":    text,
+	}
+	if blocks != nil {
+		requestData["blocks"] = blocks
+	}
+
+	jsonData, err := json.Marshal(requestData)
+	if err != nil {
+		return err
+	}
+
+	body, err := c.doRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var apiResponse SlackPostMessageResponse
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		return err
+	}
+
+	if !apiResponse.Ok {
+		return &SlackApiError{Code: apiResponse.Error, Needed: apiResponse.Needed}
+	}
+
+	return nil
+}
+
+// RespondToCommand posts a delayed slash-command response to the command's
+// response_url. The URL carries its own authorization, so no token is sent.
+func (c *HttpSlackClient) RespondToCommand(ctx context.Context, responseUrl string, payload map[string]interface{}) error {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	body, err := c.doRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", responseUrl, bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// response_url answers with a bare "ok" or, on some workspaces, the
+	// usual JSON envelope.
+	if strings.TrimSpace(string(body)) == "ok" {
+		return nil
+	}
+	var apiResponse SlackPostMessageResponse
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil || !apiResponse.Ok {
+		return fmt.Errorf("slack response_url error: %s", body)
+	}
+	return nil
+}
+
+// AddReaction adds the named emoji reaction to the message at ts.
+func (c *HttpSlackClient) AddReaction(ctx context.Context, channelId, ts, name string) error {
+	url := fmt.Sprintf("%sreactions.add", c.baseUrl())
+
+	requestData := map[string]interface{}{
+		"channel":   channelId,
+		"timestamp": ts,
+		"name":      name,
+	}
+
+	jsonData, err := json.Marshal(requestData)
+	if err != nil {
+		return err
+	}
+
+	body, err := c.doRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var apiResponse SlackPostMessageResponse
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		return err
+	}
+
+	if !apiResponse.Ok {
+		return &SlackApiError{Code: apiResponse.Error, Needed: apiResponse.Needed}
+	}
+
+	return nil
+}
+
+// FetchBotUserId returns the user ID the bot token belongs to, via
+// auth.test.
+func (c *HttpSlackClient) FetchBotUserId(ctx context.Context) (string, error) {
+	url := fmt.Sprintf("%sauth.test", c.baseUrl())
+
+	body, err := c.doRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+		return req, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var apiResponse SlackAuthTestResponse
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		return "", err
+	}
+
+	if !apiResponse.Ok {
+		return "", &SlackApiError{Code: apiResponse.Error, Needed: apiResponse.Needed}
+	}
+
+	return apiResponse.UserId, nil
+}
+
+// OpenSocketConnection returns a Socket Mode WebSocket URL via
+// apps.connections.open. It authenticates with AppToken, not Token.
+func (c *HttpSlackClient) OpenSocketConnection(ctx context.Context) (string, error) {
+	url := fmt.Sprintf("%sapps.connections.open", c.baseUrl())
+
+	body, err := c.doRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.AppToken))
+		return req, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var apiResponse SlackConnectionsOpenResponse
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		return "", err
+	}
+
+	if !apiResponse.Ok {
+		return "", &SlackApiError{Code: apiResponse.Error, Needed: apiResponse.Needed}
+	}
+
+	return apiResponse.Url, nil
+}
+
+// joinChannel adds the bot to a public channel via conversations.join.
+// Private channels fail with method_not_supported_for_channel_type or
+// channel_not_found and need an invite instead.
+func (c *HttpSlackClient) joinChannel(ctx context.Context, channelId string) error {
+	url := fmt.Sprintf("%sconversations.join", c.baseUrl())
+	jsonData, err := json.Marshal(map[string]interface{}{"channel": channelId})
+	if err != nil {
+		return err
+	}
+
+	body, err := c.doRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var apiResponse SlackPostMessageResponse
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		return err
+	}
+
+	if !apiResponse.Ok {
+		return &SlackApiError{Code: apiResponse.Error, Needed: apiResponse.Needed}
+	}
+
+	return nil
+}
+
+// OpenDirectMessage returns the ID of the bot's DM channel with userId,
+// via conversations.open.
+func (c *HttpSlackClient) OpenDirectMessage(ctx context.Context, userId string) (string, error) {
+	url := fmt.Sprintf("%sconversations.open", c.baseUrl())
+	requestBody, err := json.Marshal(map[string]interface{}{"users": userId})
+	if err != nil {
+		return "", err
+	}
+
+	body, err := c.doRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(requestBody))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+		return req, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var apiResponse SlackConversationsOpenResponse
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		return "", err
+	}
+
+	if !apiResponse.Ok {
+		return "", &SlackApiError{Code: apiResponse.Error, Needed: apiResponse.Needed}
+	}
+
+	return apiResponse.Channel.Id, nil
+}
+
+// DownloadFile returns the content of a file's url_private. Private file
+// URLs need the bot token, and the files:read scope.
+func (c *HttpSlackClient) DownloadFile(ctx context.Context, url string) ([]byte, error) {
+	return c.doRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+		return req, nil
+	})
+}
+
+// isDirectMessageChannel reports whether channelId is an IM channel. IM
+// IDs start with "D"; public and private channels start with "C" or "G".
+func isDirectMessageChannel(channelId string) bool {
+	return strings.HasPrefix(channelId, "D")
+}
+
+// FetchUserInfo returns the user's display name via users.info, falling
+// back to the real name and then the handle. Deactivated users yield an
+// empty name so callers can fall back to the raw mention.
+func (c *HttpSlackClient) FetchUserInfo(ctx context.Context, userId string) (string, error) {
+	url := fmt.Sprintf("%susers.info?user=%s", c.baseUrl(), neturl.QueryEscape(userId))
+
+	body, err := c.doRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+		return req, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var apiResponse SlackUsersInfoResponse
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		return "", err
+	}
+
+	if !apiResponse.Ok {
+		return "", &SlackApiError{Code: apiResponse.Error, Needed: apiResponse.Needed}
+	}
+
+	user := apiResponse.User
+	switch {
+	case user.Deleted:
+		return "", nil
+	case user.Profile.DisplayName != "":
+		return user.Profile.DisplayName, nil
+	case user.Profile.RealName != "":
+		return user.Profile.RealName, nil
+	default:
+		return user.Name, nil
+	}
+}
+
+// baseUrl returns BaseUrl with a trailing slash, or SlackApiBaseUrl
+// when it is unset.
+func (c *HttpSlackClient) baseUrl() string {
+	if c.BaseUrl != "" {
+		return strings.TrimRight(c.BaseUrl, "/") + "/"
+	}
+	return SlackApiBaseUrl
+}
+
+// doRequest sends the request built by newRequest and returns the
+// response body. While Slack answers 429 it sleeps for the Retry-After
+// duration and tries again, up to MaxRetries times. Any other non-2xx
+// status is an error.
+func (c *HttpSlackClient) doRequest(ctx context.Context, newRequest func() (*http.Request, error)) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		reqCtx, cancel := requestContext(req.Context(), c.Timeout)
+		resp, err := c.HttpClient.Do(req.WithContext(reqCtx))
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("%w: %w", ErrTransient, err)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusTooManyRequests {
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				return nil, &SlackHttpError{StatusCode: resp.StatusCode, Status: resp.Status}
+			}
+			return body, nil
+		}
+
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		if attempt >= c.MaxRetries {
+			return nil, &RateLimitError{RetryAfter: retryAfter}
+		}
+
+		slog.Warn("Slack rate limited, retrying", "url", req.URL.Path, "retry_after", retryAfter, "attempt", attempt+1)
+		err = sleepContext(ctx, retryAfter)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isTransientSlackError reports whether err is worth retrying: a network
+// failure or a 5xx from Slack. Errors caused by ctx ending are not.
+func isTransientSlackError(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && errors.Is(err, ErrTransient)
+}
+
+// parseRetryAfter reads a Retry-After header in seconds, defaulting to
+// one second when it is missing or malformed.
+func parseRetryAfter(v string) time.Duration {
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds < 0 {
+		return time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSlackClient(t *testing.T, handler http.HandlerFunc) *HttpSlackClient {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return &HttpSlackClient{
+		HttpClient:      server.Client(),
+		Token:           "xoxb-test",
+		BaseUrl:         server.URL + "/",
+		MaxHistoryPages: DefaultMaxHistoryPages,
+	}
+}
+
+func TestFetchMessages(t *testing.T) {
+	oldest := time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+	latest := oldest.Add(12 * time.Hour)
+
+	client := newTestSlackClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/conversations.history" {
+			t.Errorf("path = %q, want /conversations.history", r.URL.Path)
+		}
+		query := r.URL.Query()
+		if got := query.Get("channel"); got != "C123" {
+			t.Errorf("channel = %q, want C123", got)
+		}
+		if got := query.Get("oldest"); got != "1704139200" {
+			t.Errorf("oldest = %q, want 1704139200", got)
+		}
+		if got := query.Get("latest"); got != "1704182400" {
+			t.Errorf("latest = %q, want 1704182400", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer xoxb-test" {
+			t.Errorf("Authorization = %q, want Bearer xoxb-test", got)
+		}
+		w.Write([]byte(`{"ok":true,"messages":[{"type":"message","user":"U1","text":"質問です","ts":"1704150000.000100"}]}`))
+	})
+
+	messages, err := client.FetchMessages(context.Background(), "C123", oldest, latest)
+	if err != nil {
+		t.Fatalf("FetchMessages() error = %v", err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("len(messages) = %d, want 1", len(messages))
+	}
+	if messages[0].User != "U1" || messages[0].Ts != "1704150000.000100" {
+		t.Errorf("messages[0] = %+v", messages[0])
+	}
+}
+
+func TestFetchMessagesStopsAtMessageCap(t *testing.T) {
+	pages := 0
+	client := newTestSlackClient(t, func(w http.ResponseWriter, r *http.Request) {
+		pages++
+		w.Write([]byte(`{"ok":true,"has_more":true,"response_metadata":{"next_cursor":"next"},"messages":[` +
+			`{"type":"message","user":"U1","text":"a","ts":"1704150003.000100"},` +
+			`{"type":"message","user":"U1","text":"b","ts":"1704150002.000100"}]}`))
+	})
+	client.MaxMessages = 3
+
+	now := time.Now()
+	messages, err := client.FetchMessages(context.Background(), "C123", now.Add(-time.Hour), now)
+	if err != nil {
+		t.Fatalf("FetchMessages() error = %v", err)
+	}
+	if len(messages) != 3 {
+		t.Errorf("len(messages) = %d, want 3", len(messages))
+	}
+	if pages != 2 {
+		t.Errorf("pages fetched = %d, want 2", pages)
+	}
+}
+
+func TestFetchMessagesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{
+			name:    "ok false",
+			status:  http.StatusOK,
+			body:    `{"ok":false,"error":"missing_scope","needed":"channels:history"}`,
+			wantErr: "slack API error: missing_scope, needed: channels:history",
+		},
+		{
+			name:    "malformed JSON",
+			status:  http.StatusOK,
+			body:    `{"ok":`,
+			wantErr: "unexpected end of JSON input",
+		},
+		{
+			name:    "non-200 status",
+			status:  http.StatusInternalServerError,
+			body:    `<html>oops</html>`,
+			wantErr: "500 Internal Server Error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestSlackClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+
+			now := time.Now()
+			_, err := client.FetchMessages(context.Background(), "C123", now.Add(-time.Hour), now)
+			if err == nil {
+				t.Fatal("FetchMessages() error = nil, want error")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("FetchMessages() error = %q, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFetchMessagesJoinsChannel(t *testing.T) {
+	tests := []struct {
+		name     string
+		joinBody string
+		wantErr  string
+		wantMsgs int
+	}{
+		{
+			name:     "public channel",
+			joinBody: `{"ok":true}`,
+			wantMsgs: 1,
+		},
+		{
+			name:     "private channel",
+			joinBody: `{"ok":false,"error":"method_not_supported_for_channel_type"}`,
+			wantErr:  "invite it",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			joined := false
+			client := newTestSlackClient(t, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasSuffix(r.URL.Path, "/conversations.join"):
+					joined = true
+					w.Write([]byte(tt.joinBody))
+				case !joined:
+					w.Write([]byte(`{"ok":false,"error":"not_in_channel"}`))
+				default:
+					w.Write([]byte(`{"ok":true,"messages":[{"type":"message","user":"U1","text":"hi","ts":"1704150000.000100"}]}`))
+				}
+			})
+
+			now := time.Now()
+			messages, err := client.FetchMessages(context.Background(), "C123", now.Add(-time.Hour), now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("FetchMessages() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FetchMessages() error = %v", err)
+			}
+			if len(messages) != tt.wantMsgs {
+				t.Errorf("len(messages) = %d, want %d", len(messages), tt.wantMsgs)
+			}
+		})
+	}
+}
+
+func TestPostToThreadRetriesTransientFailures(t *testing.T) {
+	attempts := 0
+	client := newTestSlackClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	})
+	client.PostRetryDelay = time.Millisecond
+
+	_, err := client.PostToThread(context.Background(), "C123", "1704150000.000100", "answer")
+	if err != nil {
+		t.Fatalf("PostToThread() error = %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestPostToThreadDoesNotRetryClientErrors(t *testing.T) {
+	attempts := 0
+	client := newTestSlackClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Write([]byte(`{"ok":false,"error":"channel_not_found"}`))
+	})
+	client.PostRetryDelay = time.Millisecond
+
+	_, err := client.PostToThread(context.Background(), "C123", "1704150000.000100", "answer")
+	if err == nil || !strings.Contains(err.Error(), "channel_not_found") {
+		t.Fatalf("PostToThread() error = %v, want channel_not_found", err)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestPostToThreadReturnsTsForUpdate(t *testing.T) {
+	var updated map[string]interface{}
+	client := newTestSlackClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/chat.update") {
+			json.NewDecoder(r.Body).Decode(&updated)
+			w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true,"ts":"1704150001.000200"}`))
+	})
+
+	ts, err := client.PostToThread(context.Background(), "C123", "1704150000.000100", PlaceholderMessage)
+	if err != nil {
+		t.Fatalf("PostToThread() error = %v", err)
+	}
+	if ts != "1704150001.000200" {
+		t.Errorf("PostToThread() ts = %q, want 1704150001.000200", ts)
+	}
+
+	err = client.UpdateMessage(context.Background(), "C123", ts, "answer", nil)
+	if err != nil {
+		t.Fatalf("UpdateMessage() error = %v", err)
+	}
+	if updated["ts"] != ts || updated["text"] != "answer" {
+		t.Errorf("chat.update body = %v, want ts %s and text answer", updated, ts)
+	}
+}
+
+func TestSlackTimeoutCancelsHungRequest(t *testing.T) {
+	client := newTestSlackClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	client.Timeout = 20 * time.Millisecond
+
+	start := time.Now()
+	_, err := client.FetchBotUserId(context.Background())
+	if !errors.Is(err, ErrTransient) {
+		t.Errorf("FetchBotUserId() error = %v, want ErrTransient", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("FetchBotUserId() took %s, want it cancelled after the timeout", elapsed)
+	}
+}
+
+func TestBaseUrlTrailingSlash(t *testing.T) {
+	for _, suffix := range []string{"", "/", "//"} {
+		client := newTestSlackClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/auth.test" {
+				t.Errorf("path = %q, want /api/auth.test", r.URL.Path)
+			}
+			w.Write([]byte(`{"ok":true,"user_id":"UBOT"}`))
+		})
+		client.BaseUrl = strings.TrimSuffix(client.BaseUrl, "/") + "/api" + suffix
+
+		if _, err := client.FetchBotUserId(context.Background()); err != nil {
+			t.Errorf("FetchBotUserId() with base URL %q error = %v", client.BaseUrl, err)
+		}
+	}
+}
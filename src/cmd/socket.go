@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	SocketMinBackoff = time.Second
+	SocketMaxBackoff = time.Minute
+)
+
+// SocketModeEnvelope is a frame Slack sends over a Socket Mode connection.
+// Payload is set for "events_api" frames.
+type SocketModeEnvelope struct {
+	Type       string             `json:"type"`
+	EnvelopeId string             `json:"envelope_id"`
+	Payload    SlackEventEnvelope `json:"payload"`
+	Reason     string             `json:"reason"`
+}
+
+// ServeSocket answers questions as Slack pushes events over a Socket Mode
+// WebSocket, so no public URL is needed. It reconnects with backoff when
+// the connection drops and blocks until ctx is cancelled.
+func ServeSocket(ctx context.Context, cfg Config) error {
+	r, err := newRunner(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	queue := make(chan SlackMessage, EventQueueSize)
+	go r.work(ctx, queue)
+
+	backoff := SocketMinBackoff
+	for {
+		connected, err := r.runSocket(ctx, queue)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if connected {
+			backoff = SocketMinBackoff
+		}
+		slog.Warn("Socket Mode connection closed, reconnecting", "backoff", backoff, "error", err)
+
+		err = sleepContext(ctx, backoff)
+		if err != nil {
+			return nil
+		}
+		backoff = min(backoff*2, SocketMaxBackoff)
+	}
+}
+
+// runSocket opens one Socket Mode connection and reads frames until it
+// closes. connected reports whether Slack said hello, so the caller can
+// reset its backoff after a healthy connection.
+func (r *runner) runSocket(ctx context.Context, queue chan<- SlackMessage) (connected bool, err error) {
+	url, err := r.cfg.Slack.OpenSocketConnection(ctx)
+	if err != nil {
+		return false, fmt.Errorf("opening socket connection: %w", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("dialing socket: %w", err)
+	}
+	defer conn.Close()
+
+	// Unblock ReadJSON when the process is asked to stop.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		var envelope SocketModeEnvelope
+		err := conn.ReadJSON(&envelope)
+		if err != nil {
+			return connected, err
+		}
+
+		// Every frame with an envelope ID must be acked, whether or not
+		// it turns into an answer, or Slack redelivers it.
+		if envelope.EnvelopeId != "" {
+			ack, _ := json.Marshal(map[string]string{"envelope_id": envelope.EnvelopeId})
+			err := conn.WriteMessage(websocket.TextMessage, ack)
+			if err != nil {
+				return connected, err
+			}
+		}
+
+		switch envelope.Type {
+		case "hello":
+			connected = true
+			slog.Info("Connected to Slack Socket Mode")
+		case "disconnect":
+			return connected, fmt.Errorf("disconnect requested: %s", envelope.Reason)
+		case "events_api":
+			if envelope.Payload.Type == "event_callback" {
+				r.enqueue(queue, envelope.Payload)
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// State is persisted between runs in the file named by STATE_FILE so that
+// questions answered once are not answered again.
+type State struct {
+	// Answered holds the ts of every message the bot has replied to.
+	Answered map[string]bool `json:"answered"`
+	// Cursors holds, per channel, the ts from which the next run reads
+	// history: everything before it has been handled.
+	Cursors map[string]string `json:"cursors,omitempty"`
+}
+
+// loadState reads the state file at path. A missing file, or an empty
+// path, yields an empty state.
+func loadState(path string) (*State, error) {
+	state := &State{Answered: map[string]bool{}, Cursors: map[string]string{}}
+	if path == "" {
+		return state, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Answered == nil {
+		state.Answered = map[string]bool{}
+	}
+	if state.Cursors == nil {
+		state.Cursors = map[string]string{}
+	}
+	return state, nil
+}
+
+// saveState writes state to path via a temporary file so that a crash
+// mid-write never leaves a truncated file behind. An empty path is a no-op.
+func saveState(path string, state *State) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SummarizeCommand is the slash command that recaps a thread. Its text is
+// a link to a message in the thread, or the thread's ts. The thread must
+// be in a watched channel, the one the command was typed in.
+const SummarizeCommand = "/summarize"
+
+const (
+	// SummarizeSystemPrompt replaces the system prompt when summarizing.
+	SummarizeSystemPrompt = "You summarize Slack threads for people who were not part of them. Be concise and factual, and write in the language the thread is written in."
+	// SummarizeInstruction follows the thread's messages.
+	SummarizeInstruction = "Summarize the thread above in a few bullet points: what was asked or discussed, what was decided or answered, and anything still open."
+)
+
+var (
+	// threadTsPattern matches a Slack message ts such as 1704150000.000100.
+	threadTsPattern = regexp.MustCompile(`^\d+\.\d{6}$`)
+	// permalinkTsPattern matches the last path segment of a permalink,
+	// the ts without its dot: p1704150000000100.
+	permalinkTsPattern = regexp.MustCompile(`^p(\d+)(\d{6})$`)
+)
+
+// summarizeThread asks ChatGPT for a recap of the thread at threadTs.
+// Each message is its own history entry, so when a long thread does not
+// fit the model's context window its oldest messages are dropped first.
+func (r *runner) summarizeThread(ctx context.Context, channelId, threadTs string) (string, error) {
+	replies, err := r.cfg.Slack.FetchThreadReplies(ctx, channelId, threadTs)
+	if err != nil {
+		return "", fmt.Errorf("fetching thread %s in %s: %w", threadTs, channelId, err)
+	}
+
+	var history []ChatMessage
+	for _, reply := range replies {
+		if reply.Hidden || strings.TrimSpace(reply.Text) == "" {
+			continue
+		}
+		if reply.User == r.botUserId || reply.BotId != "" {
+			history = append(history, ChatMessage{Role: "assistant", Content: reply.Text})
+			continue
+		}
+		speaker := fmt.Sprintf("<@%s>", reply.User)
+		if name := r.userName(ctx, reply.User); name != "" {
+			speaker = name
+		}
+		history = append(history, ChatMessage{Role: "user", Content: speaker + ": " + reply.Text})
+	}
+	if len(history) == 0 {
+		return "", fmt.Errorf("thread %s in %s has no messages to summarize", threadTs, channelId)
+	}
+
+	opts := r.cfg.chatOptions(channelId)
+	opts.SystemPrompt = SummarizeSystemPrompt
+	return r.cfg.Chat.Send(ctx, history, SummarizeInstruction, opts)
+}
+
+// summarizeCommand posts a recap of the thread to the thread itself, so
+// it is only visible to people who can already read the thread, and
+// tells the caller through the command's response_url.
+func (r *runner) summarizeCommand(ctx context.Context, command SlashCommand, channelId, threadTs string) {
+	respond := func(text string) {
+		err := r.cfg.Slack.RespondToCommand(ctx, command.ResponseUrl, map[string]interface{}{
+			"response_type": "ephemeral",
+			"text":          text,
+		})
+		if err != nil {
+			slog.Error("Error responding to slash command", "channel", command.ChannelId, "user", command.UserId, "error", err)
+		}
+	}
+
+	start := time.Now()
+	summary, err := r.summarizeThread(ctx, channelId, threadTs)
+	chatGptLatency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		chatGptErrors.WithLabelValues(channelId).Inc()
+		slog.Error("Error summarizing thread", "channel", channelId, "thread_ts", threadTs, "user", command.UserId, "error", err)
+		respond(r.cfg.ErrorMessage)
+		return
+	}
+
+	text := fmt.Sprintf("Summary requested by <@%s>:\n%s", command.UserId, r.cfg.formatAnswer(summary))
+	if r.cfg.DryRun {
+		slog.Info("Dry run, not posting summary", "channel", channelId, "thread_ts", threadTs, "summary", text)
+		respond("Dry run: the summary was logged, not posted.")
+		return
+	}
+	for _, chunk := range splitMessage(text, SlackMessageLimit) {
+		_, err = r.cfg.Slack.PostToThread(ctx, channelId, threadTs, chunk)
+		if err != nil {
+			slog.Error("Error posting summary", "channel", channelId, "thread_ts", threadTs, "error", err)
+			slackErrors.WithLabelValues(channelId, "post").Inc()
+			respond("Could not post the summary to the thread.")
+			return
+		}
+	}
+
+	slog.Info("Thread summarized", "channel", channelId, "thread_ts", threadTs, "user", command.UserId)
+	respond("Posted a summary to the thread.")
+}
+
+// summarizeTarget returns the thread a /summarize command names, or an
+// error to show the caller. The recap is posted in the thread, so a
+// thread elsewhere would let the caller make the bot post in channels,
+// private ones included, that they may not be able to read.
+func (r *runner) summarizeTarget(command SlashCommand) (channelId, threadTs string, err error) {
+	channelId, threadTs, ok := parseThreadRef(command.Text, command.ChannelId)
+	if !ok {
+		return "", "", fmt.Errorf("Usage: %s <link to a message in the thread>", SummarizeCommand)
+	}
+	if channelId != command.ChannelId || !r.watchesChannel(channelId) {
+		return "", "", errors.New("Only threads in this channel can be summarized, and only in channels the bot answers in.")
+	}
+	return channelId, threadTs, nil
+}
+
+// parseThreadRef reads the thread a /summarize command names: a message
+// permalink, possibly wrapped in <> by Slack, or a bare ts in channelId.
+// A permalink to a reply names its thread through the thread_ts query.
+func parseThreadRef(text, channelId string) (string, string, bool) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "<") && strings.HasSuffix(text, ">") {
+		text, _, _ = strings.Cut(text[1:len(text)-1], "|")
+	}
+	if threadTsPattern.MatchString(text) {
+		return channelId, text, channelId != ""
+	}
+
+	u, err := neturl.Parse(text)
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "archives" || parts[1] == "" {
+		return "", "", false
+	}
+	match := permalinkTsPattern.FindStringSubmatch(parts[2])
+	if match == nil {
+		return "", "", false
+	}
+	if ts := u.Query().Get("thread_ts"); threadTsPattern.MatchString(ts) {
+		return parts[1], ts, true
+	}
+	return parts[1], match[1] + "." + match[2], true
+}
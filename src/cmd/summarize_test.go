@@ -0,0 +1,117 @@
+package main
+
+import "testing"
+
+func TestParseThreadRef(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		wantChannel string
+		wantTs      string
+		wantOk      bool
+	}{
+		{
+			name:        "bare ts",
+			text:        "1704150000.000100",
+			wantChannel: "C1",
+			wantTs:      "1704150000.000100",
+			wantOk:      true,
+		},
+		{
+			name:        "permalink to the parent",
+			text:        "https://example.slack.com/archives/C2/p1704150000000100",
+			wantChannel: "C2",
+			wantTs:      "1704150000.000100",
+			wantOk:      true,
+		},
+		{
+			name:        "permalink to a reply",
+			text:        "https://example.slack.com/archives/C2/p1704150500000200?thread_ts=1704150000.000100&cid=C2",
+			wantChannel: "C2",
+			wantTs:      "1704150000.000100",
+			wantOk:      true,
+		},
+		{
+			name:        "escaped link with label",
+			text:        " <https://example.slack.com/archives/C2/p1704150000000100|thread> ",
+			wantChannel: "C2",
+			wantTs:      "1704150000.000100",
+			wantOk:      true,
+		},
+		{
+			name:   "not a permalink",
+			text:   "https://example.com/docs/p1704150000000100",
+			wantOk: false,
+		},
+		{
+			name:   "empty",
+			text:   "",
+			wantOk: false,
+		},
+		{
+			name:   "free text",
+			text:   "the deploy thread",
+			wantOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channel, ts, ok := parseThreadRef(tt.text, "C1")
+			if ok != tt.wantOk || channel != tt.wantChannel || ts != tt.wantTs {
+				t.Errorf("parseThreadRef(%q) = %q, %q, %v, want %q, %q, %v", tt.text, channel, ts, ok, tt.wantChannel, tt.wantTs, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestSummarizeTarget(t *testing.T) {
+	r := &runner{cfg: Config{ChannelIds: []string{"C1", "C2"}}}
+	tests := []struct {
+		name      string
+		command   SlashCommand
+		wantTs    string
+		wantError bool
+	}{
+		{
+			name:    "thread in this channel",
+			command: SlashCommand{ChannelId: "C1", Text: "https://example.slack.com/archives/C1/p1704150000000100"},
+			wantTs:  "1704150000.000100",
+		},
+		{
+			name:    "bare ts in this channel",
+			command: SlashCommand{ChannelId: "C1", Text: "1704150000.000100"},
+			wantTs:  "1704150000.000100",
+		},
+		{
+			name:      "thread in another watched channel",
+			command:   SlashCommand{ChannelId: "C1", Text: "https://example.slack.com/archives/C2/p1704150000000100"},
+			wantError: true,
+		},
+		{
+			name:      "unwatched channel",
+			command:   SlashCommand{ChannelId: "C9", Text: "1704150000.000100"},
+			wantError: true,
+		},
+		{
+			name:      "not a thread",
+			command:   SlashCommand{ChannelId: "C1", Text: "yesterday's thread"},
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channelId, threadTs, err := r.summarizeTarget(tt.command)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("summarizeTarget() = %q, %q, want an error", channelId, threadTs)
+				}
+				return
+			}
+			if err != nil || channelId != tt.command.ChannelId || threadTs != tt.wantTs {
+				t.Errorf("summarizeTarget() = %q, %q, %v, want %q, %q", channelId, threadTs, err, tt.command.ChannelId, tt.wantTs)
+			}
+		})
+	}
+}
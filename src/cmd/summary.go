@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// RunSummary counts what one polling pass did, for an auditable record of
+// each scheduled run.
+type RunSummary struct {
+	MessagesFetched       int     `json:"messages_fetched"`
+	QuestionsDetected     int     `json:"questions_detected"`
+	AnswersPosted         int     `json:"answers_posted"`
+	Errors                int     `json:"errors"`
+	ChatGptLatencySeconds float64 `json:"chatgpt_latency_seconds"`
+}
+
+// record applies update to the current pass's summary.
+func (r *runner) record(update func(s *RunSummary)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	update(&r.summary)
+}
+
+// reportSummary logs the summary of the pass that just finished, posts it
+// to SummaryChannelId when set, and starts a fresh one.
+func (r *runner) reportSummary(ctx context.Context, started time.Time) {
+	r.mu.Lock()
+	summary := r.summary
+	r.summary = RunSummary{}
+	r.mu.Unlock()
+
+	slog.Info("Run summary",
+		"duration", time.Since(started),
+		"messages_fetched", summary.MessagesFetched,
+		"questions_detected", summary.QuestionsDetected,
+		"answers_posted", summary.AnswersPosted,
+		"errors", summary.Errors,
+		"chatgpt_latency_seconds", summary.ChatGptLatencySeconds)
+
+	if r.cfg.SummaryChannelId == "" || r.cfg.DryRun {
+		return
+	}
+	text, err := json.Marshal(summary)
+	if err != nil {
+		slog.Error("Error encoding run summary", "error", err)
+		return
+	}
+	_, err = r.cfg.Slack.PostToThread(ctx, r.cfg.SummaryChannelId, "", string(text))
+	if err != nil {
+		slog.Error("Error posting run summary", "channel", r.cfg.SummaryChannelId, "error", err)
+	}
+}
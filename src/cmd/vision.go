@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// MaxImageBytes is the largest image sent to ChatGPT. Larger images are
+// only mentioned in the prompt.
+const MaxImageBytes = 20 << 20
+
+// VisionModels are the model name prefixes that accept image input.
+var VisionModels = []string{"gpt-4o", "gpt-4-turbo", "gpt-4.1", "gpt-4-vision"}
+
+// supportsVision reports whether model accepts image input.
+func supportsVision(model string) bool {
+	for _, prefix := range VisionModels {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachImages downloads the message's image files as data URLs for a
+// vision model and returns them with the IDs of the files they came from.
+// A file that cannot be downloaded is skipped, leaving it to the text-only
+// note.
+func (r *runner) attachImages(ctx context.Context, channelId string, message SlackMessage) ([]string, map[string]bool) {
+	var images []string
+	attached := map[string]bool{}
+	for _, file := range message.Files {
+		if !strings.HasPrefix(file.Mimetype, "image/") || file.UrlPrivate == "" {
+			continue
+		}
+		if file.Size > MaxImageBytes {
+			slog.Info("Image too large to send, noting it only", "channel", channelId, "ts", message.Ts, "file", file.Id, "size", file.Size)
+			continue
+		}
+
+		data, err := r.cfg.Slack.DownloadFile(ctx, file.UrlPrivate)
+		if err == nil && len(data) > MaxImageBytes {
+			err = fmt.Errorf("file is %d bytes, over the %d byte limit", len(data), MaxImageBytes)
+		}
+		// Without files:read Slack answers with its HTML sign-in page.
+		if err == nil && !strings.HasPrefix(http.DetectContentType(data), "image/") {
+			err = fmt.Errorf("downloaded %s, not an image", http.DetectContentType(data))
+		}
+		if err != nil {
+			slog.Warn("Error downloading image, answering from text only", "channel", channelId, "ts", message.Ts, "file", file.Id, "error", err)
+			slackErrors.WithLabelValues(channelId, "download").Inc()
+			continue
+		}
+
+		images = append(images, fmt.Sprintf("data:%s;base64,%s", file.Mimetype, base64.StdEncoding.EncodeToString(data)))
+		attached[file.Id] = true
+	}
+	return images, attached
+}